@@ -33,7 +33,7 @@ fi
 # Step 1: Build Enhanced WASM
 echo -e "${BLUE}Step 1: Building Enhanced WASM Module (Phase 1 + Phase 2)${NC}"
 echo "Features: Progress, Smart groups, Caching, Image similarity (pHash)"
-GOOS=js GOARCH=wasm go build -ldflags="-s -w" -o main.wasm main_wasm_enhanced.go phash.go
+GOOS=js GOARCH=wasm go build -ldflags="-s -w" -o main.wasm main_wasm_enhanced.go
 
 if [ $? -eq 0 ]; then
     SIZE=$(du -h main.wasm | cut -f1)
@@ -77,7 +77,7 @@ echo ""
 
 # Step 3: Build MCP Server
 echo -e "${BLUE}Step 3: Building MCP Server${NC}"
-go build -o mcp-server mcp-server.go
+go build -o mcp-server .
 
 if [ $? -eq 0 ]; then
     echo -e "${GREEN}✅ MCP Server built${NC}"
@@ -85,6 +85,15 @@ else
     echo "❌ MCP Server build failed"
     exit 1
 fi
+
+go build -o pure-dupes ./cmd/pure-dupes
+
+if [ $? -eq 0 ]; then
+    echo -e "${GREEN}✅ pure-dupes CLI built${NC}"
+else
+    echo "❌ pure-dupes CLI build failed"
+    exit 1
+fi
 echo ""
 
 # Step 4: Prepare files
@@ -116,7 +125,8 @@ echo "  ├─ wasm_exec.js ($(du -h wasm_exec.js 2>/dev/null | cut -f1 || echo
 echo "  ├─ wasm-worker.js"
 echo "  ├─ cache-db.js (inlined in HTML)"
 echo "  ├─ index.html"
-echo "  └─ mcp-server"
+echo "  ├─ mcp-server"
+echo "  └─ pure-dupes (CLI: ./pure-dupes scan DIR)"
 echo ""
 echo "🚀 To test:"
 echo "  ./serve.sh"
@@ -1,4 +1,5 @@
 // cache-db.js - IndexedDB wrapper for file hash caching
+// index.html inlines a copy of CacheDB; change both together.
 const DB_NAME = 'pure-dupes-cache';
 const DB_VERSION = 1;
 const HASH_STORE = 'file-hashes';
@@ -59,6 +60,29 @@ class CacheDB {
         });
     }
     
+    // Store a signature returned by analyzeIncremental. It is kept in its
+    // JSON form, whose 64-bit hashes are strings, so nothing is rounded.
+    async putSignature(signature) {
+        return new Promise((resolve, reject) => {
+            const transaction = this.db.transaction([HASH_STORE], 'readwrite');
+            const store = transaction.objectStore(HASH_STORE);
+            
+            const data = {
+                path: signature.path,
+                hash: signature.root,
+                size: signature.size,
+                modTime: signature.modTime,
+                chunks: (signature.leaves || []).length,
+                signature,
+                timestamp: Date.now()
+            };
+            
+            const request = store.put(data);
+            request.onsuccess = () => resolve();
+            request.onerror = () => reject(request.error);
+        });
+    }
+    
     // Get file hash
     async getFileHash(path) {
         return new Promise((resolve, reject) => {
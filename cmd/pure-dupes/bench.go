@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// ============================================================================
+// BENCH
+// ============================================================================
+//
+// bench times chunk indexing and candidate search on synthetic inputs of
+// doubling size. With linear scaling the per-leaf cost stays flat as the
+// file count grows.
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	start := fs.Int("start", 1000, "smallest file count")
+	maxFiles := fs.Int("files", 64000, "largest file count for the index benchmark")
+	leaves := fs.Int("leaves", 32, "chunks per synthetic file")
+	analyzeFiles := fs.Int("analyze-files", 8000, "largest file count for the end-to-end benchmark (0 to skip)")
+	concurrency := fs.Int("concurrency", 0, "files hashed in parallel (0 = one per CPU)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pure-dupes bench [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *start < 1 || *leaves < 1 {
+		return fmt.Errorf("-start and -leaves must be positive")
+	}
+
+	fmt.Printf("Chunk index (%d leaves per file)\n", *leaves)
+	fmt.Printf("%10s %12s %12s %10s %12s %10s\n", "files", "leaves", "index", "ns/leaf", "candidates", "ns/leaf")
+	for n := *start; n <= *maxFiles; n *= 2 {
+		trees := syntheticTrees(n, *leaves)
+		total := n * *leaves
+
+		began := time.Now()
+		index := dupes.BuildChunkIndex(trees)
+		indexTime := time.Since(began)
+
+		began = time.Now()
+		for _, ft := range trees {
+			dupes.FindCandidates(ft, index, 0.8)
+		}
+		candidateTime := time.Since(began)
+
+		fmt.Printf("%10d %12d %12s %10.1f %12s %10.1f\n",
+			n, total,
+			indexTime.Round(time.Microsecond), float64(indexTime.Nanoseconds())/float64(total),
+			candidateTime.Round(time.Microsecond), float64(candidateTime.Nanoseconds())/float64(total))
+	}
+
+	if *analyzeFiles <= 0 {
+		return nil
+	}
+
+	opts := dupes.DefaultOptions()
+	opts.Chunker = dupes.FixedChunker{Size: 1024}
+	opts.Concurrency = *concurrency
+
+	fmt.Printf("\nFindDuplicates (5 KB files, families of 4 sharing 4 of 5 chunks)\n")
+	fmt.Printf("%10s %12s %12s %10s\n", "files", "time", "us/file", "groups")
+	for n := *start; n <= *analyzeFiles; n *= 2 {
+		files := syntheticFiles(n, 1024)
+
+		began := time.Now()
+		result := dupes.FindDuplicates(files, opts)
+		elapsed := time.Since(began)
+
+		fmt.Printf("%10d %12s %10.1f %10d\n",
+			n, elapsed.Round(time.Millisecond), float64(elapsed.Microseconds())/float64(n), len(result.DuplicateGroups))
+	}
+
+	return nil
+}
+
+// syntheticTrees builds n FileTrees in families of four: each file has one
+// chunk of its own and shares the rest with its family.
+func syntheticTrees(n, leaves int) []dupes.FileTree {
+	trees := make([]dupes.FileTree, n)
+	for i := range trees {
+		family := i / 4
+		ft := dupes.FileTree{
+			Path:    "bench/" + strconv.Itoa(i),
+			Leaves:  make([]string, leaves),
+			HashAlg: "sha256",
+			Stage:   dupes.StageFull,
+			Root:    []byte(strconv.Itoa(i)),
+		}
+		ft.Leaves[0] = "own-" + strconv.Itoa(i)
+		for j := 1; j < leaves; j++ {
+			ft.Leaves[j] = "fam-" + strconv.Itoa(family) + "-" + strconv.Itoa(j)
+		}
+		trees[i] = ft
+	}
+	return trees
+}
+
+// syntheticFiles is syntheticTrees as file contents: five chunkSize chunks,
+// the first unique and the other four shared by a family of four files.
+func syntheticFiles(n, chunkSize int) []dupes.JSFile {
+	chunk := func(seed int64) []byte {
+		data := make([]byte, chunkSize)
+		rand.New(rand.NewSource(seed)).Read(data)
+		return data
+	}
+
+	files := make([]dupes.JSFile, n)
+	for i := range files {
+		family := int64(i / 4)
+		data := chunk(int64(i) + 1<<40)
+		for j := int64(1); j < 5; j++ {
+			data = append(data, chunk(family*8+j)...)
+		}
+		name := strconv.Itoa(i) + ".bin"
+		files[i] = dupes.JSFile{
+			Name: name,
+			Path: "bench/" + name,
+			Size: int64(len(data)),
+			Data: data,
+		}
+	}
+	return files
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+	"github.com/vinodhalaharvi/pure-dupes/hashdb"
+)
+
+// ============================================================================
+// CATALOG
+// ============================================================================
+//
+// catalog build indexes a reference tree into a hash database; catalog check
+// reports which files of another tree the reference already holds.
+
+const catalogUsage = `Usage: pure-dupes catalog <subcommand> [flags] CATALOG DIR...
+
+Subcommands:
+  build   Hash DIRs into CATALOG (unchanged files are skipped on rebuilds)
+  check   Report which files under DIRs already exist in CATALOG
+`
+
+func runCatalog(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, catalogUsage)
+		return fmt.Errorf("catalog needs a subcommand")
+	}
+
+	switch args[0] {
+	case "build":
+		return runCatalogBuild(args[1:])
+	case "check":
+		return runCatalogCheck(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, catalogUsage)
+		return fmt.Errorf("unknown catalog subcommand: %s", args[0])
+	}
+}
+
+func runCatalogBuild(args []string) error {
+	fs := flag.NewFlagSet("catalog build", flag.ExitOnError)
+	var analysis analysisFlags
+	var walk walkFlags
+	analysis.register(fs)
+	walk.register(fs)
+	progress := fs.Bool("progress", false, "show progress on stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pure-dupes catalog build [flags] CATALOG DIR...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("catalog build needs a catalog file and at least one directory")
+	}
+
+	opts, err := analysis.options()
+	if err != nil {
+		return err
+	}
+	if *progress {
+		opts.Progress = printProgress
+	}
+
+	files, err := dupes.CollectFiles(fs.Args()[1:], walk.options())
+	if err != nil {
+		return err
+	}
+
+	db, err := hashdb.Open(fs.Arg(0), hashdb.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	hashed, err := hashdb.Index(context.Background(), db, files, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Catalogued %d files (%d hashed, %d unchanged)\n", len(files), hashed, len(files)-hashed)
+	return printDBStats(db)
+}
+
+func runCatalogCheck(args []string) error {
+	fs := flag.NewFlagSet("catalog check", flag.ExitOnError)
+	var walk walkFlags
+	walk.register(fs)
+	threshold := fs.Float64("threshold", 0.8, "similarity threshold (0.0-1.0) for partial matches")
+	metric := fs.String("metric", "overlap", "partial match score: "+strings.Join(dupes.SimilarityMetricNames(), ", "))
+	stopFiles := fs.Int("stop-files", 0, "ignore chunks found in more than this many catalogued files when matching (0 = keep all)")
+	stopEntropy := fs.Bool("stop-low-entropy", true, "ignore low-entropy chunks (zero fill, padding) when matching")
+	concurrency := fs.Int("concurrency", 0, "files hashed in parallel (0 = one per CPU)")
+	asJSON := fs.Bool("json", false, "print every file's matches as JSON")
+	progress := fs.Bool("progress", false, "show progress on stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pure-dupes catalog check [flags] CATALOG DIR...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("catalog check needs a catalog file and at least one directory")
+	}
+
+	db, err := hashdb.Open(fs.Arg(0), hashdb.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	catalog, err := hashdb.OpenCatalog(db)
+	if err != nil {
+		return err
+	}
+
+	files, err := dupes.CollectFiles(fs.Args()[1:], walk.options())
+	if err != nil {
+		return err
+	}
+
+	opts := dupes.DefaultOptions()
+	opts.Threshold = *threshold
+	if opts.Metric, err = dupes.LookupSimilarityMetric(*metric); err != nil {
+		return err
+	}
+	opts.StopChunks = dupes.StopChunkOptions{MaxFiles: *stopFiles, LowEntropy: *stopEntropy}
+	opts.Concurrency = *concurrency
+	opts.OnError = func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	if *progress {
+		opts.Progress = printProgress
+	}
+
+	results, err := catalog.Check(context.Background(), files, opts)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		if r.Status == "error" {
+			fmt.Printf("%-8s %s: %s\n", r.Status, r.Path, r.Error)
+			continue
+		}
+		if len(r.Matches) == 0 {
+			fmt.Printf("%-8s %s\n", r.Status, r.Path)
+			continue
+		}
+		best := r.Matches[0]
+		fmt.Printf("%-8s %s (%.0f%% %s)\n", r.Status, r.Path, best.Similarity*100, best.TargetPath)
+	}
+	fmt.Printf("\nChecked %d files against %d catalogued (%s, %d-byte chunks, %s): %d exact, %d partial, %d visual, %d new, %d unreadable\n",
+		len(results), catalog.Files, catalog.Chunker.Name, catalog.Chunker.Size, catalog.HashAlg,
+		counts["exact"], counts["partial"], counts["visual"], counts["new"], counts["error"])
+	return nil
+}
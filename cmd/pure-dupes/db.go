@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+	"github.com/vinodhalaharvi/pure-dupes/hashdb"
+)
+
+// ============================================================================
+// HASH DATABASE
+// ============================================================================
+//
+// db inspects and maintains the hash database that scan -db keeps, so
+// repeated scans only rehash files that changed.
+
+const dbUsage = `Usage: pure-dupes db <subcommand> FILE
+
+Subcommands:
+  stats FILE     Show record counts, size and reclaimable garbage
+  compact FILE   Rewrite the database with only live records
+`
+
+func runDB(args []string) error {
+	if len(args) != 2 {
+		fmt.Fprint(os.Stderr, dbUsage)
+		return fmt.Errorf("db needs a subcommand and a database file")
+	}
+
+	switch args[0] {
+	case "stats":
+		db, err := hashdb.Open(args[1], hashdb.Options{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return printDBStats(db)
+
+	case "compact":
+		db, err := hashdb.Open(args[1], hashdb.Options{})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		before, err := db.Stats()
+		if err != nil {
+			return err
+		}
+		if err := db.Compact(); err != nil {
+			return err
+		}
+		fmt.Printf("Compacted %s: %s -> %s\n", args[1], formatBytes(before.FileBytes), formatBytes(before.LiveBytes))
+		return nil
+
+	default:
+		fmt.Fprint(os.Stderr, dbUsage)
+		return fmt.Errorf("unknown db subcommand: %s", args[0])
+	}
+}
+
+func printDBStats(db *hashdb.DB) error {
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database:     %s\n", stats.Path)
+	fmt.Printf("Files:        %d\n", stats.Files)
+	fmt.Printf("Records:      %d\n", stats.Records)
+	fmt.Printf("Chunk hashes: %d\n", stats.Chunks)
+	fmt.Printf("Size:         %s\n", formatBytes(stats.FileBytes))
+	fmt.Printf("Garbage:      %.0f%% (%s reclaimable by compact)\n",
+		stats.Garbage*100, formatBytes(stats.FileBytes-stats.LiveBytes))
+	return nil
+}
+
+// analyzeWithDB runs the analysis, through the hash database when path is set.
+func analyzeWithDB(path string, files []dupes.JSFile, opts dupes.Options) (dupes.DedupResult, error) {
+	if path == "" {
+		return dupes.FindDuplicates(files, opts), nil
+	}
+
+	db, err := hashdb.Open(path, hashdb.Options{})
+	if err != nil {
+		return dupes.DedupResult{}, err
+	}
+	defer db.Close()
+
+	result, _, err := hashdb.Analyze(context.Background(), db, files, opts)
+	return result, err
+}
@@ -0,0 +1,285 @@
+// pure-dupes - command-line duplicate finder built on the same core as the
+// WASM module.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+const usage = `Usage: pure-dupes <command> [flags] [args]
+
+Commands:
+  scan DIR...   Find exact, partial and visual duplicates under DIRs
+  serve         Run a local HTTP/JSON API for starting and watching scans
+  bench         Time chunk indexing and FindDuplicates on synthetic data
+  db            Show stats for or compact a hash database (see scan -db)
+  catalog       Build a reference catalog and check other trees against it
+  diff A B      Show the byte ranges where two versions of a file differ
+
+Run "pure-dupes <command> -h" for command flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "scan":
+		err = runScan(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "bench":
+		err = runBench(os.Args[2:])
+	case "db":
+		err = runDB(os.Args[2:])
+	case "catalog":
+		err = runCatalog(os.Args[2:])
+	case "diff":
+		err = runDiff(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pure-dupes: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ============================================================================
+// SHARED FLAGS
+// ============================================================================
+
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+type analysisFlags struct {
+	threshold   float64
+	chunkSize   int
+	chunker     string
+	minChunk    int
+	maxChunk    int
+	hash        string
+	metric      string
+	stopFiles   int
+	stopEntropy bool
+	linkage     string
+	visualSave  string
+	prefilterKB int
+	noPartial   bool
+	concurrency int
+}
+
+func (a *analysisFlags) register(fs *flag.FlagSet) {
+	fs.Float64Var(&a.threshold, "threshold", 0.8, "similarity threshold (0.0-1.0) for partial matches")
+	fs.IntVar(&a.chunkSize, "chunk-size", 4096, "chunk size in bytes (average size for cdc)")
+	fs.StringVar(&a.chunker, "chunker", "fixed", "chunker: fixed, cdc, line or record")
+	fs.IntVar(&a.minChunk, "min-chunk", 0, "minimum chunk size for cdc")
+	fs.IntVar(&a.maxChunk, "max-chunk", 0, "maximum chunk size for cdc, line and record")
+	fs.StringVar(&a.hash, "hash", "sha256", "hash algorithm: "+strings.Join(dupes.HashAlgorithmNames(), ", "))
+	fs.StringVar(&a.metric, "metric", "overlap", "partial match score: "+strings.Join(dupes.SimilarityMetricNames(), ", "))
+	fs.IntVar(&a.stopFiles, "stop-files", 0, "ignore chunks found in more than this many files when matching (0 = keep all)")
+	fs.BoolVar(&a.stopEntropy, "stop-low-entropy", true, "ignore low-entropy chunks (zero fill, padding) when matching")
+	fs.StringVar(&a.linkage, "linkage", "single", "how similar files cluster into groups: single, complete or centroid")
+	fs.StringVar(&a.visualSave, "visual-savings", "keep-largest", "how visual groups count as savings: keep-largest, keep-smallest or none")
+	fs.IntVar(&a.prefilterKB, "prefilter-kb", 0, "head/tail KB hashed before full hashing (needs -no-partial)")
+	fs.BoolVar(&a.noPartial, "no-partial", false, "skip partial duplicate detection")
+	fs.IntVar(&a.concurrency, "concurrency", 0, "files hashed in parallel (0 = one per CPU)")
+}
+
+func (a *analysisFlags) options() (dupes.Options, error) {
+	chunker, err := dupes.NewChunker(dupes.ChunkerSpec{
+		Name:    a.chunker,
+		Size:    a.chunkSize,
+		MinSize: a.minChunk,
+		MaxSize: a.maxChunk,
+	})
+	if err != nil {
+		return dupes.Options{}, err
+	}
+
+	hash, err := dupes.LookupHashAlgorithm(a.hash)
+	if err != nil {
+		return dupes.Options{}, err
+	}
+
+	metric, err := dupes.LookupSimilarityMetric(a.metric)
+	if err != nil {
+		return dupes.Options{}, err
+	}
+
+	if a.prefilterKB > 0 && !a.noPartial {
+		return dupes.Options{}, fmt.Errorf("-prefilter-kb needs -no-partial: partial matching hashes every file in full")
+	}
+
+	linkage, err := dupes.ParseLinkage(a.linkage)
+	if err != nil {
+		return dupes.Options{}, err
+	}
+
+	visualSavings, err := dupes.ParseVisualSavings(a.visualSave)
+	if err != nil {
+		return dupes.Options{}, err
+	}
+
+	return dupes.Options{
+		Threshold:      a.threshold,
+		Chunker:        chunker,
+		Hash:           hash,
+		Metric:         metric,
+		StopChunks:     dupes.StopChunkOptions{MaxFiles: a.stopFiles, LowEntropy: a.stopEntropy},
+		Linkage:        linkage,
+		VisualSavings:  visualSavings,
+		PrefilterBytes: a.prefilterKB * 1024,
+		SkipPartial:    a.noPartial,
+		Concurrency:    a.concurrency,
+		OnError: func(path string, err error) {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		},
+	}, nil
+}
+
+type walkFlags struct {
+	include        stringList
+	exclude        stringList
+	maxDepth       int
+	followSymlinks bool
+	hidden         bool
+}
+
+func (w *walkFlags) register(fs *flag.FlagSet) {
+	fs.Var(&w.include, "include", "only scan files matching this glob (repeatable)")
+	fs.Var(&w.exclude, "exclude", "skip files and directories matching this glob (repeatable)")
+	fs.IntVar(&w.maxDepth, "max-depth", 0, "maximum directory depth (0 = unlimited)")
+	fs.BoolVar(&w.followSymlinks, "follow-symlinks", false, "follow symbolic links")
+	fs.BoolVar(&w.hidden, "hidden", false, "include hidden files and directories")
+}
+
+func (w *walkFlags) options() dupes.WalkOptions {
+	return dupes.WalkOptions{
+		Include:        w.include,
+		Exclude:        w.exclude,
+		MaxDepth:       w.maxDepth,
+		FollowSymlinks: w.followSymlinks,
+		IncludeHidden:  w.hidden,
+		OnError: func(path string, err error) {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		},
+	}
+}
+
+func printProgress(current, total int, message string, percent float64) {
+	fmt.Fprintf(os.Stderr, "\r\033[K[%3.0f%%] %s", percent, message)
+	if percent >= 100 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// ============================================================================
+// SCAN
+// ============================================================================
+
+func runScan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	var analysis analysisFlags
+	var walk walkFlags
+	analysis.register(fs)
+	walk.register(fs)
+	asJSON := fs.Bool("json", false, "print the full DedupResult as JSON")
+	progress := fs.Bool("progress", false, "show progress on stderr")
+	dbPath := fs.String("db", os.Getenv("PURE_DUPES_DB"), "hash database reused between scans; only changed files are rehashed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pure-dupes scan [flags] DIR...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("scan needs at least one directory")
+	}
+
+	opts, err := analysis.options()
+	if err != nil {
+		return err
+	}
+	if *progress {
+		opts.Progress = printProgress
+	}
+
+	files, err := dupes.CollectFiles(fs.Args(), walk.options())
+	if err != nil {
+		return err
+	}
+
+	result, err := analyzeWithDB(*dbPath, files, opts)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	printSummary(result)
+	return nil
+}
+
+func printSummary(result dupes.DedupResult) {
+	fmt.Printf("Files scanned:      %d\n", result.TotalFiles)
+	fmt.Printf("Unique files:       %d\n", result.UniqueFiles)
+	fmt.Printf("Exact duplicates:   %d\n", result.FullDupCount)
+	fmt.Printf("Partial duplicates: %d\n", result.PartialDupCount)
+	fmt.Printf("Visual duplicates:  %d\n", result.VisualDupCount)
+	fmt.Printf("Space saved:        %s exact, %s similar (chunk dedup), %s visual\n",
+		formatBytes(result.Savings.Exact), formatBytes(result.Savings.Similar), formatBytes(result.Savings.Visual))
+	fmt.Printf("Chunker:            %s (%d bytes), hash %s\n", result.Chunker.Name, result.Chunker.Size, result.HashAlgorithm)
+	if len(result.StopChunks) > 0 {
+		fmt.Printf("Stop chunks:        %d ignored (most common in %d files)\n", len(result.StopChunks), result.StopChunks[0].Files)
+	}
+	fmt.Printf("Processing time:    %.2fs\n", result.ProcessingTime)
+
+	for i, group := range result.DuplicateGroups {
+		similarity := fmt.Sprintf("%.0f%% similar", group.Similarity*100)
+		if group.MinSimilarity != group.MaxSimilarity {
+			similarity = fmt.Sprintf("%.0f%% similar (%.0f-%.0f%%)", group.Similarity*100, group.MinSimilarity*100, group.MaxSimilarity*100)
+		}
+		fmt.Printf("\nGroup %d: %s, %s, saves %s\n", i+1, group.GroupType, similarity, formatBytes(group.Savings))
+		for _, path := range group.Files {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// ============================================================================
+// SERVE
+// ============================================================================
+//
+// HTTP API:
+//
+//	POST   /scans               start a scan: an application/json scanRequest,
+//	                            or multipart with "files" parts and an optional
+//	                            "options" field holding a JSON scanRequest
+//	GET    /scans               status of every scan
+//	GET    /scans/{id}          status and progress
+//	DELETE /scans/{id}          cancel and forget a scan
+//	GET    /scans/{id}/events   server-sent progress events, then "done"
+//	GET    /scans/{id}/result   the full DedupResult
+//	GET    /scans/{id}/groups   DuplicateGroups, ?offset=&limit=&type=
+//
+// Finished scans are forgotten after -keep-for, or sooner once more than
+// -keep-scans of them have piled up.
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var analysis analysisFlags
+	var walk walkFlags
+	analysis.register(fs)
+	walk.register(fs)
+	addr := fs.String("addr", "127.0.0.1:7777", "listen address")
+	maxScans := fs.Int("max-scans", 2, "scans run at the same time; more are queued")
+	maxUpload := fs.Int64("max-upload-mb", 512, "largest multipart upload accepted, in MB")
+	keepScans := fs.Int("keep-scans", 20, "finished scans kept; the oldest are forgotten first")
+	keepFor := fs.Duration("keep-for", time.Hour, "how long a finished scan is kept")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pure-dupes serve [flags]")
+		fmt.Fprintln(fs.Output(), "Analysis and walk flags set defaults that each POST /scans can override.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *maxScans < 1 {
+		return fmt.Errorf("-max-scans must be at least 1")
+	}
+	if *keepScans < 1 || *keepFor <= 0 {
+		return fmt.Errorf("-keep-scans and -keep-for must be positive")
+	}
+	// Check the defaults once so bad flags fail at startup, not per request
+	if _, err := analysis.options(); err != nil {
+		return err
+	}
+
+	srv := &scanServer{
+		defaults:  newScanRequest(analysis, walk),
+		jobs:      make(map[string]*scanJob),
+		slots:     make(chan struct{}, *maxScans),
+		maxUpload: *maxUpload << 20,
+		keepScans: *keepScans,
+		keepFor:   *keepFor,
+	}
+
+	log.Printf("🌐 pure-dupes API listening on http://%s", *addr)
+	return http.ListenAndServe(*addr, srv)
+}
+
+// scanRequest is the body of POST /scans. Fields left out keep the server's
+// flag defaults.
+type scanRequest struct {
+	Paths          []string `json:"paths"`
+	Include        []string `json:"include,omitempty"`
+	Exclude        []string `json:"exclude,omitempty"`
+	MaxDepth       int      `json:"maxDepth"`
+	FollowSymlinks bool     `json:"followSymlinks"`
+	Hidden         bool     `json:"hidden"`
+	Threshold      float64  `json:"threshold"`
+	ChunkSize      int      `json:"chunkSize"`
+	Chunker        string   `json:"chunker"`
+	MinChunk       int      `json:"minChunk"`
+	MaxChunk       int      `json:"maxChunk"`
+	Hash           string   `json:"hash"`
+	Metric         string   `json:"metric"`
+	StopFiles      int      `json:"stopFiles"`
+	StopEntropy    bool     `json:"stopLowEntropy"`
+	Linkage        string   `json:"linkage"`
+	VisualSavings  string   `json:"visualSavings"`
+	PrefilterKB    int      `json:"prefilterKB"`
+	NoPartial      bool     `json:"noPartial"`
+	Concurrency    int      `json:"concurrency"`
+}
+
+func newScanRequest(a analysisFlags, w walkFlags) scanRequest {
+	return scanRequest{
+		Include:        w.include,
+		Exclude:        w.exclude,
+		MaxDepth:       w.maxDepth,
+		FollowSymlinks: w.followSymlinks,
+		Hidden:         w.hidden,
+		Threshold:      a.threshold,
+		ChunkSize:      a.chunkSize,
+		Chunker:        a.chunker,
+		MinChunk:       a.minChunk,
+		MaxChunk:       a.maxChunk,
+		Hash:           a.hash,
+		Metric:         a.metric,
+		StopFiles:      a.stopFiles,
+		StopEntropy:    a.stopEntropy,
+		Linkage:        a.linkage,
+		VisualSavings:  a.visualSave,
+		PrefilterKB:    a.prefilterKB,
+		NoPartial:      a.noPartial,
+		Concurrency:    a.concurrency,
+	}
+}
+
+// clone copies r with its own slices, so decoding a request into it cannot
+// write through to the server defaults it was copied from.
+func (r scanRequest) clone() scanRequest {
+	r.Paths = append([]string(nil), r.Paths...)
+	r.Include = append([]string(nil), r.Include...)
+	r.Exclude = append([]string(nil), r.Exclude...)
+	return r
+}
+
+func (r scanRequest) flags() (analysisFlags, walkFlags) {
+	return analysisFlags{
+		threshold:   r.Threshold,
+		chunkSize:   r.ChunkSize,
+		chunker:     r.Chunker,
+		minChunk:    r.MinChunk,
+		maxChunk:    r.MaxChunk,
+		hash:        r.Hash,
+		metric:      r.Metric,
+		stopFiles:   r.StopFiles,
+		stopEntropy: r.StopEntropy,
+		linkage:     r.Linkage,
+		visualSave:  r.VisualSavings,
+		prefilterKB: r.PrefilterKB,
+		noPartial:   r.NoPartial,
+		concurrency: r.Concurrency,
+	}, walkFlags{
+		include:        r.Include,
+		exclude:        r.Exclude,
+		maxDepth:       r.MaxDepth,
+		followSymlinks: r.FollowSymlinks,
+		hidden:         r.Hidden,
+	}
+}
+
+// ============================================================================
+// JOBS
+// ============================================================================
+
+type progressEvent struct {
+	Current int     `json:"current"`
+	Total   int     `json:"total"`
+	Message string  `json:"message"`
+	Percent float64 `json:"percent"`
+}
+
+// scanTotals are the headline counts of a finished DedupResult.
+type scanTotals struct {
+	TotalFiles      int                    `json:"totalFiles"`
+	UniqueFiles     int                    `json:"uniqueFiles"`
+	FullDupCount    int                    `json:"fullDupCount"`
+	PartialDupCount int                    `json:"partialDupCount"`
+	VisualDupCount  int                    `json:"visualDupCount"`
+	Groups          int                    `json:"groups"`
+	SpaceSaved      int64                  `json:"spaceSaved"`
+	Savings         dupes.SavingsBreakdown `json:"savings"`
+	ProcessingTime  float64                `json:"processingTime"`
+}
+
+type scanStatus struct {
+	ID         string         `json:"id"`
+	State      string         `json:"state"` // queued, running, done, failed, cancelled
+	Paths      []string       `json:"paths"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+	Progress   *progressEvent `json:"progress,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Totals     *scanTotals    `json:"totals,omitempty"`
+}
+
+type scanJob struct {
+	mu          sync.Mutex
+	status      scanStatus
+	result      *dupes.DedupResult
+	cancel      context.CancelFunc
+	subscribers map[chan progressEvent]bool
+}
+
+func (j *scanJob) snapshot() scanStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+func (j *scanJob) setState(state string) {
+	j.mu.Lock()
+	j.status.State = state
+	j.mu.Unlock()
+}
+
+func (j *scanJob) publish(current, total int, message string, percent float64) {
+	ev := progressEvent{Current: current, Total: total, Message: message, Percent: percent}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Progress = &ev
+	for ch := range j.subscribers {
+		// Slow readers miss intermediate events rather than stalling the scan
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of progress events that is closed when the
+// scan finishes. The latest event, if any, is delivered first.
+func (j *scanJob) subscribe() (chan progressEvent, func()) {
+	ch := make(chan progressEvent, 16)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Progress != nil {
+		ch <- *j.status.Progress
+	}
+	if j.status.FinishedAt != nil {
+		close(ch)
+		return ch, func() {}
+	}
+	j.subscribers[ch] = true
+
+	return ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.subscribers[ch] {
+			delete(j.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (j *scanJob) finish(result *dupes.DedupResult, err error) {
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.FinishedAt = &now
+	switch {
+	case err == context.Canceled:
+		j.status.State = "cancelled"
+	case err != nil:
+		j.status.State = "failed"
+		j.status.Error = err.Error()
+	default:
+		j.status.State = "done"
+		j.result = result
+		j.status.Totals = &scanTotals{
+			TotalFiles:      result.TotalFiles,
+			UniqueFiles:     result.UniqueFiles,
+			FullDupCount:    result.FullDupCount,
+			PartialDupCount: result.PartialDupCount,
+			VisualDupCount:  result.VisualDupCount,
+			Groups:          len(result.DuplicateGroups),
+			SpaceSaved:      result.SpaceSaved,
+			Savings:         result.Savings,
+			ProcessingTime:  result.ProcessingTime,
+		}
+	}
+	for ch := range j.subscribers {
+		close(ch)
+	}
+	j.subscribers = map[chan progressEvent]bool{}
+}
+
+// ============================================================================
+// SERVER
+// ============================================================================
+
+// maxScanRequest bounds a JSON scan request, which only lists paths and
+// settings; uploads are bounded by maxUpload instead.
+const maxScanRequest = 1 << 20
+
+type scanServer struct {
+	defaults  scanRequest
+	maxUpload int64
+	keepScans int           // finished scans kept
+	keepFor   time.Duration // how long a finished scan is kept
+
+	mu    sync.Mutex
+	jobs  map[string]*scanJob
+	slots chan struct{} // one token per running scan
+}
+
+func (s *scanServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "scans" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.listScans(w)
+		case http.MethodPost:
+			s.createScan(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+		return
+	}
+
+	job, ok := s.job(parts[1])
+	if !ok {
+		writeError(w, http.StatusNotFound, "no scan "+parts[1])
+		return
+	}
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, job.snapshot())
+		case http.MethodDelete:
+			s.deleteScan(w, parts[1], job)
+		default:
+			methodNotAllowed(w, "GET, DELETE")
+		}
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	switch parts[2] {
+	case "events":
+		streamEvents(w, r, job)
+	case "result":
+		if result, ok := finishedResult(w, job); ok {
+			writeJSON(w, http.StatusOK, result)
+		}
+	case "groups":
+		if result, ok := finishedResult(w, job); ok {
+			writeGroups(w, r, result.DuplicateGroups)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *scanServer) job(id string) (*scanJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// prune forgets finished scans older than keepFor, then the oldest finished
+// ones beyond keepScans. Running and queued scans are never pruned.
+func (s *scanServer) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type finished struct {
+		id string
+		at time.Time
+	}
+	var done []finished
+	for id, job := range s.jobs {
+		if at := job.snapshot().FinishedAt; at != nil {
+			done = append(done, finished{id, *at})
+		}
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].at.After(done[j].at) })
+
+	cutoff := time.Now().Add(-s.keepFor)
+	for i, f := range done {
+		if i >= s.keepScans || f.at.Before(cutoff) {
+			delete(s.jobs, f.id)
+		}
+	}
+}
+
+func (s *scanServer) listScans(w http.ResponseWriter) {
+	s.mu.Lock()
+	statuses := make([]scanStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		statuses = append(statuses, job.snapshot())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.Before(statuses[j].CreatedAt)
+	})
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *scanServer) createScan(w http.ResponseWriter, r *http.Request) {
+	s.prune()
+
+	req := s.defaults.clone()
+	var uploads []dupes.JSFile
+
+	// Requiring application/json keeps a plain cross-site form post from
+	// starting a scan of server paths
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		var err error
+		if req, uploads, err = s.readUpload(w, r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case "application/json":
+		r.Body = http.MaxBytesReader(w, r.Body, maxScanRequest)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid scan request: "+err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json or multipart/form-data")
+		return
+	}
+
+	if len(req.Paths) == 0 && len(uploads) == 0 {
+		writeError(w, http.StatusBadRequest, "paths or uploaded files required")
+		return
+	}
+
+	analysis, walk := req.flags()
+	opts, err := analysis.options()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &scanJob{
+		status: scanStatus{
+			ID:        newScanID(),
+			State:     "queued",
+			Paths:     req.Paths,
+			CreatedAt: time.Now(),
+		},
+		cancel:      cancel,
+		subscribers: map[chan progressEvent]bool{},
+	}
+	if len(uploads) > 0 {
+		job.status.Paths = dupes.Map(uploads, func(f dupes.JSFile) string { return f.Path })
+	}
+	opts.Progress = job.publish
+
+	s.mu.Lock()
+	s.jobs[job.status.ID] = job
+	s.mu.Unlock()
+
+	go s.run(ctx, job, req.Paths, walk.options(), uploads, opts)
+
+	w.Header().Set("Location", "/scans/"+job.status.ID)
+	writeJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+// readUpload reads a multipart scan: every "files" part becomes a JSFile and
+// an "options" field may carry a JSON scanRequest.
+func (s *scanServer) readUpload(w http.ResponseWriter, r *http.Request) (scanRequest, []dupes.JSFile, error) {
+	req := s.defaults.clone()
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return req, nil, err
+	}
+
+	files := []dupes.JSFile{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return req, nil, err
+		}
+
+		switch part.FormName() {
+		case "options":
+			if err := json.NewDecoder(part).Decode(&req); err != nil {
+				return req, nil, fmt.Errorf("invalid options: %v", err)
+			}
+		case "files":
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return req, nil, err
+			}
+			files = append(files, dupes.JSFile{
+				Name: part.FileName(),
+				Path: part.FileName(),
+				Size: int64(len(data)),
+				Data: data,
+			})
+		}
+	}
+
+	// Uploaded files are scanned instead of server paths
+	req.Paths = nil
+	return req, files, nil
+}
+
+func (s *scanServer) run(ctx context.Context, job *scanJob, paths []string, walk dupes.WalkOptions, files []dupes.JSFile, opts dupes.Options) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		job.finish(nil, ctx.Err())
+		return
+	}
+	defer func() { <-s.slots }()
+
+	job.setState("running")
+	log.Printf("Scan %s started: %s", job.status.ID, strings.Join(job.snapshot().Paths, ", "))
+
+	opts.OnError = func(path string, err error) {
+		log.Printf("Scan %s: could not read %s: %v", job.status.ID, path, err)
+	}
+	if len(paths) > 0 {
+		walk.OnError = func(path string, err error) {
+			log.Printf("Scan %s: skipping %s: %v", job.status.ID, path, err)
+		}
+		collected, err := dupes.CollectFilesContext(ctx, paths, walk)
+		if err != nil {
+			job.finish(nil, err)
+			return
+		}
+		files = collected
+	}
+
+	result, _, err := dupes.AnalyzeContext(ctx, files, opts)
+	if err != nil {
+		job.finish(nil, err)
+		return
+	}
+	job.finish(&result, nil)
+	log.Printf("Scan %s finished: %d files, %d groups", job.status.ID, result.TotalFiles, len(result.DuplicateGroups))
+}
+
+func (s *scanServer) deleteScan(w http.ResponseWriter, id string, job *scanJob) {
+	job.cancel()
+
+	s.mu.Lock()
+	delete(s.jobs, id)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ============================================================================
+// RESPONSES
+// ============================================================================
+
+func streamEvents(w http.ResponseWriter, r *http.Request, job *scanJob) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	events, unsubscribe := job.subscribe()
+	defer unsubscribe()
+
+	send := func(name string, v interface{}) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				send("done", job.snapshot())
+				return
+			}
+			send("progress", ev)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func finishedResult(w http.ResponseWriter, job *scanJob) (*dupes.DedupResult, bool) {
+	job.mu.Lock()
+	result, state := job.result, job.status.State
+	job.mu.Unlock()
+
+	if result == nil {
+		writeError(w, http.StatusConflict, "scan is "+state)
+		return nil, false
+	}
+	return result, true
+}
+
+type groupPage struct {
+	Total      int                    `json:"total"`
+	Offset     int                    `json:"offset"`
+	Limit      int                    `json:"limit"`
+	NextOffset int                    `json:"nextOffset,omitempty"`
+	Groups     []dupes.DuplicateGroup `json:"groups"`
+}
+
+func writeGroups(w http.ResponseWriter, r *http.Request, groups []dupes.DuplicateGroup) {
+	query := r.URL.Query()
+	offset := queryInt(query.Get("offset"), 0)
+	limit := queryInt(query.Get("limit"), 50)
+	if limit < 1 || limit > 1000 {
+		limit = 50
+	}
+
+	if groupType := query.Get("type"); groupType != "" {
+		groups = dupes.Filter(groups, func(g dupes.DuplicateGroup) bool { return g.GroupType == groupType })
+	}
+
+	page := groupPage{
+		Total:  len(groups),
+		Offset: offset,
+		Limit:  limit,
+		Groups: []dupes.DuplicateGroup{},
+	}
+	if offset < len(groups) {
+		end := offset + limit
+		if end < len(groups) {
+			page.NextOffset = end
+		} else {
+			end = len(groups)
+		}
+		page.Groups = groups[offset:end]
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func queryInt(value string, fallback int) int {
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func newScanID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a scanServer with the flag defaults.
+func newTestServer(t *testing.T) (*scanServer, *httptest.Server) {
+	t.Helper()
+	var analysis analysisFlags
+	var walk walkFlags
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	analysis.register(fs)
+	walk.register(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &scanServer{
+		defaults:  newScanRequest(analysis, walk),
+		jobs:      make(map[string]*scanJob),
+		slots:     make(chan struct{}, 1),
+		maxUpload: 1 << 20,
+		keepScans: 20,
+		keepFor:   time.Hour,
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// scanDir writes two copies of one file and an unrelated one.
+func scanDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	shared := strings.Repeat("duplicate content ", 1000)
+	for name, content := range map[string]string{"a": shared, "copy-of-a": shared, "b": "unrelated"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func startScan(t *testing.T, ts *httptest.Server, body string) scanStatus {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/scans", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /scans: %s", resp.Status)
+	}
+	var status scanStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/scans/"+status.ID {
+		t.Errorf("Location = %q", loc)
+	}
+	return status
+}
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitDone reads the event stream until the "done" event.
+func waitDone(t *testing.T, ts *httptest.Server, id string) scanStatus {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/scans/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("events Content-Type = %q", ct)
+	}
+
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok && event == "done" {
+			var status scanStatus
+			if err := json.Unmarshal([]byte(data), &status); err != nil {
+				t.Fatal(err)
+			}
+			return status
+		}
+	}
+	t.Fatalf("event stream ended without done: %v", scanner.Err())
+	return scanStatus{}
+}
+
+func TestServeScanLifecycle(t *testing.T) {
+	_, ts := newTestServer(t)
+	dir := scanDir(t)
+
+	body, _ := json.Marshal(map[string][]string{"paths": {dir}})
+	status := startScan(t, ts, string(body))
+
+	done := waitDone(t, ts, status.ID)
+	if done.State != "done" || done.Totals == nil || done.Totals.TotalFiles != 3 || done.Totals.Groups != 1 {
+		t.Fatalf("finished scan: %+v", done)
+	}
+
+	var page groupPage
+	getJSON(t, ts.URL+"/scans/"+status.ID+"/groups", &page)
+	if page.Total != 1 || len(page.Groups) != 1 || page.Groups[0].GroupType != "exact" {
+		t.Errorf("groups: %+v", page)
+	}
+
+	// Paging past the end, or with a bad limit, stays in bounds
+	cases := map[string]groupPage{
+		"?offset=5":            {Total: 1, Offset: 5, Limit: 50},
+		"?limit=0":             {Total: 1, Limit: 50},
+		"?limit=5000&offset=x": {Total: 1, Limit: 50},
+		"?type=visual":         {Total: 0, Limit: 50},
+	}
+	for query, want := range cases {
+		var got groupPage
+		if code := getJSON(t, ts.URL+"/scans/"+status.ID+"/groups"+query, &got); code != http.StatusOK {
+			t.Errorf("%s: %d", query, code)
+		}
+		if got.Total != want.Total || got.Offset != want.Offset || got.Limit != want.Limit || got.NextOffset != 0 {
+			t.Errorf("%s: %+v, want %+v", query, got, want)
+		}
+		if want.Total == 0 || want.Offset > 0 {
+			if len(got.Groups) != 0 {
+				t.Errorf("%s: %d groups, want none", query, len(got.Groups))
+			}
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/scans/"+status.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE: %s", resp.Status)
+	}
+	if code := getJSON(t, ts.URL+"/scans/"+status.ID, nil); code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: %d, want 404", code)
+	}
+}
+
+func TestServeDeleteCancelsQueuedScan(t *testing.T) {
+	srv, ts := newTestServer(t)
+	srv.slots <- struct{}{} // the only slot is busy, so the scan stays queued
+
+	body, _ := json.Marshal(map[string][]string{"paths": {scanDir(t)}})
+	status := startScan(t, ts, string(body))
+	job, _ := srv.job(status.ID)
+
+	var result map[string]string
+	if code := getJSON(t, ts.URL+"/scans/"+status.ID+"/result", &result); code != http.StatusConflict {
+		t.Errorf("result of a queued scan: %d, want 409", code)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/scans/"+status.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.snapshot().State != "cancelled" {
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %q, want cancelled", job.snapshot().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeRequiresJSONContentType(t *testing.T) {
+	_, ts := newTestServer(t)
+	body := `{"paths": ["/"]}`
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		resp, err := http.Post(ts.URL+"/scans", contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: %s, want 415", contentType, resp.Status)
+		}
+	}
+}
+
+func TestServeRejectsOversizedJSON(t *testing.T) {
+	_, ts := newTestServer(t)
+	body, _ := json.Marshal(map[string][]string{"paths": {t.TempDir()}, "exclude": {strings.Repeat("x", maxScanRequest)}})
+	resp, err := http.Post(ts.URL+"/scans", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(msg), "too large") {
+		t.Errorf("%s: %s, want 400 with a too large error", resp.Status, msg)
+	}
+}
+
+func TestServeRequestsDoNotChangeDefaults(t *testing.T) {
+	srv, ts := newTestServer(t)
+	srv.defaults.Include = []string{"*.txt"}
+
+	body, _ := json.Marshal(map[string][]string{"paths": {scanDir(t)}, "include": {"*"}})
+	waitDone(t, ts, startScan(t, ts, string(body)).ID)
+	if got := srv.defaults.Include; len(got) != 1 || got[0] != "*.txt" {
+		t.Errorf("defaults.Include = %v after a request set its own", got)
+	}
+}
+
+func TestServePrunesFinishedScans(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.keepScans = 1
+
+	now := time.Now()
+	finishedAt := func(d time.Duration) *scanJob {
+		at := now.Add(-d)
+		return &scanJob{status: scanStatus{State: "done", FinishedAt: &at}}
+	}
+	srv.jobs = map[string]*scanJob{
+		"expired": finishedAt(2 * time.Hour),
+		"older":   finishedAt(time.Minute),
+		"newest":  finishedAt(time.Second),
+		"running": {status: scanStatus{State: "running"}},
+	}
+	srv.prune()
+
+	var left []string
+	for id := range srv.jobs {
+		left = append(left, id)
+	}
+	sort.Strings(left)
+	if want := []string{"newest", "running"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("kept %v, want %v", left, want)
+	}
+}
@@ -0,0 +1,83 @@
+package dupes
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Run with go test -bench . ./dupes; with linear scaling ns/leaf and
+// ns/file stay flat as the sizes grow.
+
+// benchTrees builds n FileTrees in families of four: each file has one chunk
+// of its own and shares the rest with its family.
+func benchTrees(n, leaves int) []FileTree {
+	trees := make([]FileTree, n)
+	for i := range trees {
+		ft := FileTree{
+			Path:    "bench/" + strconv.Itoa(i),
+			Root:    []byte(strconv.Itoa(i)),
+			Leaves:  make([]string, leaves),
+			HashAlg: "sha256",
+			Stage:   StageFull,
+		}
+		ft.Leaves[0] = "own-" + strconv.Itoa(i)
+		for j := 1; j < leaves; j++ {
+			ft.Leaves[j] = fmt.Sprintf("fam-%d-%d", i/4, j)
+		}
+		trees[i] = ft
+	}
+	return trees
+}
+
+// benchFiles is benchTrees as file contents: five chunkSize chunks, the
+// first unique and the other four shared by a family of four files.
+func benchFiles(n, chunkSize int) []JSFile {
+	chunk := func(seed int64) []byte {
+		data := make([]byte, chunkSize)
+		rand.New(rand.NewSource(seed)).Read(data)
+		return data
+	}
+
+	files := make([]JSFile, n)
+	for i := range files {
+		family := int64(i / 4)
+		data := chunk(int64(i) + 1<<40)
+		for j := int64(1); j < 5; j++ {
+			data = append(data, chunk(family*8+j)...)
+		}
+		name := strconv.Itoa(i) + ".bin"
+		files[i] = JSFile{Name: name, Path: "bench/" + name, Size: int64(len(data)), Data: data}
+	}
+	return files
+}
+
+func BenchmarkBuildChunkIndex(b *testing.B) {
+	const leaves = 32
+	for _, n := range []int{1000, 4000, 16000, 64000} {
+		trees := benchTrees(n, leaves)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				BuildChunkIndex(trees)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n*leaves), "ns/leaf")
+		})
+	}
+}
+
+func BenchmarkFindDuplicates(b *testing.B) {
+	opts := DefaultOptions()
+	opts.Chunker = FixedChunker{Size: 1024}
+	for _, n := range []int{500, 1000, 2000, 4000} {
+		files := benchFiles(n, 1024)
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				FindDuplicates(files, opts)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/file")
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package dupes
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================================
+// CHUNKERS
+// ============================================================================
+
+// Chunker decides where a file's content is cut into Merkle leaves.
+// Split returns a bufio.SplitFunc so the same chunker can drive both
+// in-memory slicing and streaming readers; tokens keep every input byte.
+type Chunker interface {
+	Spec() ChunkerSpec
+	Split(path string) bufio.SplitFunc
+}
+
+// ChunkerSpec names a chunker and its parameters. It is what callers pass in
+// to select a chunker and what DedupResult records about the run.
+type ChunkerSpec struct {
+	Name    string // "fixed", "cdc", "line", "record"
+	Size    int    // chunk size for "fixed", average size for "cdc"
+	MinSize int
+	MaxSize int
+}
+
+func NewChunker(spec ChunkerSpec) (Chunker, error) {
+	// Line chunkers only need a maximum; everything else needs a size
+	if spec.Size <= 0 && !(spec.Name == "line" && spec.MaxSize > 0) {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", spec.Size)
+	}
+
+	switch spec.Name {
+	case "", "fixed":
+		return FixedChunker{Size: spec.Size}, nil
+
+	case "cdc":
+		params := DefaultCDCParams(spec.Size)
+		if spec.MinSize > 0 {
+			params.MinSize = spec.MinSize
+		}
+		if spec.MaxSize > 0 {
+			params.MaxSize = spec.MaxSize
+		}
+		if params.MinSize > params.AvgSize || params.AvgSize > params.MaxSize {
+			return nil, fmt.Errorf("cdc sizes must satisfy min <= avg <= max, got %d/%d/%d",
+				params.MinSize, params.AvgSize, params.MaxSize)
+		}
+		return CDCChunker{Params: params}, nil
+
+	case "line":
+		return LineChunker{MaxSize: maxRecordSize(spec)}, nil
+
+	case "record":
+		return RecordChunker{
+			MaxSize:  maxRecordSize(spec),
+			Fallback: FixedChunker{Size: spec.Size},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown chunker: %s", spec.Name)
+	}
+}
+
+func maxRecordSize(spec ChunkerSpec) int {
+	if spec.MaxSize > 0 {
+		return spec.MaxSize
+	}
+	return spec.Size * 16
+}
+
+// ChunkData cuts an in-memory buffer using the chunker's split function.
+func ChunkData(c Chunker, path string, data []byte) [][]byte {
+	split := c.Split(path)
+	chunks := [][]byte{}
+
+	for len(data) > 0 {
+		advance, token, err := split(data, true)
+		if err != nil || advance <= 0 {
+			chunks = append(chunks, data)
+			break
+		}
+		chunks = append(chunks, token)
+		data = data[advance:]
+	}
+
+	return chunks
+}
+
+// FixedChunker cuts every Size bytes.
+type FixedChunker struct {
+	Size int
+}
+
+func (c FixedChunker) Spec() ChunkerSpec {
+	return ChunkerSpec{Name: "fixed", Size: c.Size}
+}
+
+func (c FixedChunker) Split(string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) >= c.Size {
+			return c.Size, data[:c.Size], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// CDCChunker cuts at content-defined boundaries (FastCDC), so an insertion
+// only disturbs the chunks around it.
+type CDCChunker struct {
+	Params CDCParams
+}
+
+func (c CDCChunker) Spec() ChunkerSpec {
+	return ChunkerSpec{
+		Name:    "cdc",
+		Size:    c.Params.AvgSize,
+		MinSize: c.Params.MinSize,
+		MaxSize: c.Params.MaxSize,
+	}
+}
+
+func (c CDCChunker) Split(string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		cut := fastCDCCut(data, c.Params)
+		// A cut at the end of a short buffer may just be where the data ran out
+		if cut == len(data) && cut < c.Params.MaxSize && !atEOF {
+			return 0, nil, nil
+		}
+		return cut, data[:cut], nil
+	}
+}
+
+// LineChunker makes one chunk per line, keeping the newline. Lines longer than
+// MaxSize are cut at MaxSize.
+type LineChunker struct {
+	MaxSize int
+}
+
+func (c LineChunker) Spec() ChunkerSpec {
+	return ChunkerSpec{Name: "line", MaxSize: c.MaxSize}
+}
+
+func (c LineChunker) Split(string) bufio.SplitFunc {
+	return delimitedSplit(c.MaxSize, func(window []byte) int {
+		return bytes.IndexByte(window, '\n')
+	})
+}
+
+// RecordChunker makes one chunk per record for formats it recognises by
+// extension and defers to Fallback for everything else.
+type RecordChunker struct {
+	MaxSize  int
+	Fallback Chunker
+}
+
+func (c RecordChunker) Spec() ChunkerSpec {
+	spec := c.Fallback.Spec()
+	return ChunkerSpec{Name: "record", Size: spec.Size, MaxSize: c.MaxSize}
+}
+
+func (c RecordChunker) Split(path string) bufio.SplitFunc {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".tsv":
+		return delimitedSplit(c.MaxSize, csvRecordEnd)
+	case ".jsonl", ".ndjson", ".log":
+		return LineChunker{MaxSize: c.MaxSize}.Split(path)
+	default:
+		return c.Fallback.Split(path)
+	}
+}
+
+// csvRecordEnd finds the newline ending the first record, ignoring newlines
+// inside quoted fields.
+func csvRecordEnd(window []byte) int {
+	quoted := false
+	for i, b := range window {
+		switch b {
+		case '"':
+			quoted = !quoted
+		case '\n':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func delimitedSplit(maxSize int, end func([]byte) int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		window := data
+		if len(window) > maxSize {
+			window = window[:maxSize]
+		}
+
+		if i := end(window); i >= 0 {
+			return i + 1, data[:i+1], nil
+		}
+		if len(window) == maxSize || atEOF {
+			return len(window), window, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// ============================================================================
+// FASTCDC
+// ============================================================================
+
+// CDCParams bounds the chunk sizes produced by the content-defined chunker.
+// AvgSize should be a power of two; it is rounded down otherwise.
+type CDCParams struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// DefaultCDCParams derives min/max bounds from the desired average chunk size,
+// using the ratios recommended by the FastCDC paper (avg/4 and avg*8).
+func DefaultCDCParams(avgSize int) CDCParams {
+	if avgSize < 64 {
+		avgSize = 64
+	}
+	return CDCParams{
+		MinSize: avgSize / 4,
+		AvgSize: avgSize,
+		MaxSize: avgSize * 8,
+	}
+}
+
+// fastCDCCut returns the length of the next chunk at the start of data.
+// Boundaries depend only on the bytes preceding them, so inserting or deleting
+// bytes only moves the boundaries around the edit.
+func fastCDCCut(data []byte, p CDCParams) int {
+	n := len(data)
+	if n <= p.MinSize {
+		return n
+	}
+	if n > p.MaxSize {
+		n = p.MaxSize
+	}
+
+	normal := p.AvgSize
+	if normal > n {
+		normal = n
+	}
+
+	// Normalized chunking: a stricter mask before the average size and a
+	// looser one after it keeps chunk sizes clustered around AvgSize.
+	level := bits.Len(uint(p.AvgSize)) - 1
+	maskS := highMask(level + 1)
+	maskL := highMask(level - 1)
+
+	var fp uint64
+	i := p.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// highMask sets the top n bits. The gear hash shifts left each byte, so the
+// high bits cover the widest window of input.
+func highMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n >= 64 {
+		return ^uint64(0)
+	}
+	return ^uint64(0) << uint(64-n)
+}
+
+var gearTable = func() [256]uint64 {
+	// splitmix64 with a fixed seed so boundaries are stable across builds
+	var table [256]uint64
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
@@ -0,0 +1,84 @@
+package dupes
+
+import (
+	"bufio"
+	"bytes"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestCDCChunksStayWithinBounds(t *testing.T) {
+	c := CDCChunker{Params: DefaultCDCParams(1024)}
+	data := randomBytes(1, 256<<10)
+
+	chunks := ChunkData(c, "", data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not reassemble the input")
+	}
+	for i, chunk := range chunks {
+		last := i == len(chunks)-1
+		if len(chunk) > c.Params.MaxSize || (!last && len(chunk) < c.Params.MinSize) {
+			t.Errorf("chunk %d is %d bytes, outside [%d, %d]", i, len(chunk), c.Params.MinSize, c.Params.MaxSize)
+		}
+	}
+	if avg := len(data) / len(chunks); avg < c.Params.AvgSize/2 || avg > c.Params.AvgSize*2 {
+		t.Errorf("average chunk is %d bytes, want about %d", avg, c.Params.AvgSize)
+	}
+
+	// A reader that hands over one byte at a time must see the same cuts
+	scanner := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(data)))
+	scanner.Buffer(make([]byte, 0, 4096), c.Params.MaxSize)
+	scanner.Split(c.Split(""))
+	var streamed [][]byte
+	for scanner.Scan() {
+		streamed = append(streamed, append([]byte{}, scanner.Bytes()...))
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed) != len(chunks) {
+		t.Fatalf("streaming made %d chunks, in memory %d", len(streamed), len(chunks))
+	}
+	for i := range chunks {
+		if !bytes.Equal(streamed[i], chunks[i]) {
+			t.Fatalf("chunk %d differs between streaming and in memory", i)
+		}
+	}
+}
+
+func TestCDCBoundariesSurviveInsertions(t *testing.T) {
+	data := randomBytes(2, 256<<10)
+	edits := map[string][]byte{
+		"insert at start":  append([]byte("inserted"), data...),
+		"insert in middle": append(append(append([]byte{}, data[:100000]...), "inserted"...), data[100000:]...),
+		"delete in middle": append(append([]byte{}, data[:100000]...), data[100100:]...),
+	}
+
+	for _, chunker := range []Chunker{CDCChunker{Params: DefaultCDCParams(1024)}, FixedChunker{Size: 1024}} {
+		original := map[string]bool{}
+		for _, chunk := range ChunkData(chunker, "", data) {
+			original[string(chunk)] = true
+		}
+		for name, edited := range edits {
+			changed := 0
+			for _, chunk := range ChunkData(chunker, "", edited) {
+				if !original[string(chunk)] {
+					changed++
+				}
+			}
+			// CDC resynchronises within a chunk or two; fixed cuts shift for good
+			if cdc := chunker.Spec().Name == "cdc"; cdc && changed > 3 {
+				t.Errorf("cdc, %s: %d new chunks, want at most 3", name, changed)
+			} else if !cdc && changed < 100 {
+				t.Errorf("fixed, %s: only %d new chunks; the comparison proves nothing", name, changed)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package dupes
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ============================================================================
+// CLUSTERING
+// ============================================================================
+//
+// Similar and visual groups are built by agglomerative clustering over the
+// matches. Every match is an undirected edge; edges are merged strongest
+// first, ties broken by path, so the same matches always give the same
+// groups whatever order they were found in.
+
+// Linkage decides when two clusters of similar files merge.
+type Linkage string
+
+const (
+	LinkageSingle   Linkage = "single"   // any match between the clusters; A~B~C is one group
+	LinkageComplete Linkage = "complete" // every file of one cluster matches every file of the other
+	LinkageCentroid Linkage = "centroid" // the most central member of each cluster match
+)
+
+// ParseLinkage checks a linkage name; "" is single.
+func ParseLinkage(name string) (Linkage, error) {
+	switch l := Linkage(name); l {
+	case "":
+		return LinkageSingle, nil
+	case LinkageSingle, LinkageComplete, LinkageCentroid:
+		return l, nil
+	default:
+		return "", fmt.Errorf("unknown linkage: %s (available: %s, %s, %s)", name, LinkageSingle, LinkageComplete, LinkageCentroid)
+	}
+}
+
+// cluster is a group of similar files and the similarity of the matches
+// between its members.
+type cluster struct {
+	files         []string
+	min, avg, max float64
+}
+
+type edge struct {
+	a, b       string // a < b
+	similarity float64
+}
+
+// clusterMatches groups the files of matches under linkage. A pair matched
+// in both directions counts once, at the higher similarity. Clusters come out
+// sorted by their first path, members sorted by path.
+func clusterMatches(matches map[string][]DuplicateMatch, linkage Linkage) []cluster {
+	weights := make(map[[2]string]float64)
+	for src, ms := range matches {
+		for _, m := range ms {
+			if m.TargetPath != src {
+				key := pairKey(src, m.TargetPath)
+				weights[key] = max(weights[key], m.Similarity)
+			}
+		}
+	}
+
+	edges := make([]edge, 0, len(weights))
+	for key, similarity := range weights {
+		edges = append(edges, edge{a: key[0], b: key[1], similarity: similarity})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].similarity != edges[j].similarity {
+			return edges[i].similarity > edges[j].similarity
+		}
+		if edges[i].a != edges[j].a {
+			return edges[i].a < edges[j].a
+		}
+		return edges[i].b < edges[j].b
+	})
+
+	linked := func(x, y string) bool {
+		_, ok := weights[pairKey(x, y)]
+		return ok
+	}
+
+	uf := newUnionFind()
+	for _, e := range edges {
+		x, y := uf.find(e.a), uf.find(e.b)
+		if x == y {
+			continue
+		}
+
+		merge := true
+		switch linkage {
+		case LinkageComplete:
+			for _, p := range uf.members(x) {
+				for _, q := range uf.members(y) {
+					merge = merge && linked(p, q)
+				}
+			}
+		case LinkageCentroid:
+			merge = linked(medoid(uf.members(x), weights), medoid(uf.members(y), weights))
+		}
+		if merge {
+			uf.union(x, y)
+		}
+	}
+
+	// Similarity stats over the matches inside each cluster
+	byRoot := make(map[string]*cluster)
+	counts := make(map[string]int)
+	for _, e := range edges {
+		root := uf.find(e.a)
+		if root != uf.find(e.b) {
+			continue
+		}
+		c, ok := byRoot[root]
+		if !ok {
+			c = &cluster{files: uf.members(root), min: e.similarity, max: e.similarity}
+			byRoot[root] = c
+		}
+		c.min = min(c.min, e.similarity)
+		c.max = max(c.max, e.similarity)
+		c.avg += e.similarity
+		counts[root]++
+	}
+
+	clusters := make([]cluster, 0, len(byRoot))
+	for root, c := range byRoot {
+		c.avg /= float64(counts[root])
+		sort.Strings(c.files)
+		clusters = append(clusters, *c)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].files[0] < clusters[j].files[0] })
+	return clusters
+}
+
+func pairKey(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// medoid is the member with the highest total similarity to the others,
+// the smallest path on ties.
+func medoid(members []string, weights map[[2]string]float64) string {
+	best, bestScore := "", -1.0
+	for _, p := range members {
+		score := 0.0
+		for _, q := range members {
+			if p != q {
+				score += weights[pairKey(p, q)]
+			}
+		}
+		if score > bestScore || (score == bestScore && p < best) {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// unionFind tracks disjoint sets of paths and their members.
+type unionFind struct {
+	parent map[string]string
+	sets   map[string][]string // root → members
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{parent: make(map[string]string), sets: make(map[string][]string)}
+}
+
+func (u *unionFind) find(p string) string {
+	parent, ok := u.parent[p]
+	if !ok {
+		u.parent[p] = p
+		u.sets[p] = []string{p}
+		return p
+	}
+	if parent == p {
+		return p
+	}
+	root := u.find(parent)
+	u.parent[p] = root
+	return root
+}
+
+func (u *unionFind) members(root string) []string {
+	return u.sets[root]
+}
+
+// union merges the sets rooted at x and y, keeping the larger set's root.
+func (u *unionFind) union(x, y string) {
+	if len(u.sets[x]) < len(u.sets[y]) {
+		x, y = y, x
+	}
+	u.parent[y] = x
+	u.sets[x] = append(u.sets[x], u.sets[y]...)
+	delete(u.sets, y)
+}
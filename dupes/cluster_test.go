@@ -0,0 +1,83 @@
+package dupes
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type match struct {
+	src, target string
+	similarity  float64
+}
+
+func matchesOf(ms ...match) map[string][]DuplicateMatch {
+	matches := map[string][]DuplicateMatch{}
+	for _, m := range ms {
+		matches[m.src] = append(matches[m.src], DuplicateMatch{TargetPath: m.target, Similarity: m.similarity})
+	}
+	return matches
+}
+
+func clusterFiles(clusters []cluster) [][]string {
+	return Map(clusters, func(c cluster) []string { return c.files })
+}
+
+func TestLinkageSplitsChains(t *testing.T) {
+	// a~b and b~c, but a and c do not match
+	chain := matchesOf(
+		match{"a", "b", 0.9},
+		match{"c", "b", 0.85},
+	)
+	cases := map[Linkage][][]string{
+		LinkageSingle:   {{"a", "b", "c"}},
+		LinkageComplete: {{"a", "b"}},
+		LinkageCentroid: {{"a", "b"}},
+	}
+	for linkage, want := range cases {
+		if got := clusterFiles(clusterMatches(chain, linkage)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %v, want %v", linkage, got, want)
+		}
+	}
+
+	single := clusterMatches(chain, LinkageSingle)[0]
+	if single.min != 0.85 || single.max != 0.9 || single.avg != 0.875 {
+		t.Errorf("single cluster stats = %+v", single)
+	}
+}
+
+func TestClusterOrderIgnoresInputOrder(t *testing.T) {
+	// Equal similarities: with complete linkage the result depends on which
+	// edge is merged first, so only a fixed edge order keeps it stable
+	pairs := []match{
+		{"a", "b", 0.9},
+		{"b", "c", 0.9},
+		{"c", "d", 0.9},
+		{"e", "f", 0.8},
+		{"f", "e", 0.7}, // the same pair seen from the other side
+	}
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		shuffled := append([]match{}, pairs...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		for j := range shuffled {
+			if rng.Intn(2) == 0 {
+				shuffled[j].src, shuffled[j].target = shuffled[j].target, shuffled[j].src
+			}
+		}
+		if got := clusterFiles(clusterMatches(matchesOf(shuffled...), LinkageComplete)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("order %v: %v, want %v", shuffled, got, want)
+		}
+	}
+}
+
+func TestParseLinkage(t *testing.T) {
+	if l, err := ParseLinkage(""); err != nil || l != LinkageSingle {
+		t.Errorf(`ParseLinkage("") = %q, %v`, l, err)
+	}
+	if _, err := ParseLinkage("average"); err == nil {
+		t.Error("unknown linkage accepted")
+	}
+}
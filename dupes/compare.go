@@ -0,0 +1,69 @@
+package dupes
+
+// ============================================================================
+// CHUNK RANGES
+// ============================================================================
+
+// ChunkRange is a run of identical content found in two files.
+type ChunkRange struct {
+	AOffset int64
+	BOffset int64
+	Length  int64
+	Chunks  int
+}
+
+// MatchingRanges lists the byte ranges of a whose chunks also occur in b.
+// Each chunk of b is matched at most once, and neighbouring matches that are
+// contiguous in both files are merged into one range. Chunks in stop are
+// never matched; unlike WithoutChunks this keeps the offsets of the rest.
+func MatchingRanges(a, b FileTree, stop map[string]bool) []ChunkRange {
+	ranges := []ChunkRange{}
+	if a.HashAlg != b.HashAlg || len(a.ChunkSizes) != len(a.Leaves) || len(b.ChunkSizes) != len(b.Leaves) {
+		return ranges
+	}
+
+	offsetsB := chunkOffsets(b)
+	available := make(map[string][]int64)
+	for i, leaf := range b.Leaves {
+		if !stop[leaf] {
+			available[leaf] = append(available[leaf], offsetsB[i])
+		}
+	}
+
+	offsetsA := chunkOffsets(a)
+	for i, leaf := range a.Leaves {
+		queue := available[leaf]
+		if len(queue) == 0 {
+			continue
+		}
+		bOff := queue[0]
+		available[leaf] = queue[1:]
+
+		aOff := offsetsA[i]
+		length := int64(a.ChunkSizes[i])
+
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if last.AOffset+last.Length == aOff && last.BOffset+last.Length == bOff {
+				last.Length += length
+				last.Chunks++
+				continue
+			}
+		}
+
+		ranges = append(ranges, ChunkRange{AOffset: aOff, BOffset: bOff, Length: length, Chunks: 1})
+	}
+
+	return ranges
+}
+
+// chunkOffsets returns the starting byte offset of each leaf.
+func chunkOffsets(ft FileTree) []int64 {
+	offsets := make([]int64, len(ft.ChunkSizes))
+	var pos int64
+	for i, size := range ft.ChunkSizes {
+		offsets[i] = pos
+		pos += int64(size)
+	}
+	return offsets
+}
@@ -0,0 +1,30 @@
+package dupes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchingRangesSkipsStopChunks(t *testing.T) {
+	chunker := FixedChunker{Size: 64}
+	zeros := strings.Repeat("\x00", 128)
+	shared := strings.Repeat("shared text ", 16)[:128]
+	hash := func(path, content string) FileTree {
+		ft, err := ProcessFile(JSFile{Path: path, Size: int64(len(content)), Data: []byte(content)}, chunker, DefaultOptions().Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ft
+	}
+	a := hash("a", zeros+shared+strings.Repeat("a", 64))
+	b := hash("b", strings.Repeat("b", 64)+zeros+shared)
+
+	if got, want := MatchingRanges(a, b, nil), []ChunkRange{{AOffset: 0, BOffset: 64, Length: 256, Chunks: 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without stop chunks: %+v, want %+v", got, want)
+	}
+	stop := map[string]bool{a.Leaves[0]: true}
+	if got, want := MatchingRanges(a, b, stop), []ChunkRange{{AOffset: 128, BOffset: 192, Length: 128, Chunks: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with zero fill stopped: %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build js
+
+package dupes
+
+// DefaultConcurrency is 1 under WASM: the Go runtime there has a single
+// thread, so extra goroutines only add scheduling overhead. Browsers get
+// parallelism by running several Web Workers instead.
+func DefaultConcurrency() int {
+	return 1
+}
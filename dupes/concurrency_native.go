@@ -0,0 +1,11 @@
+//go:build !js
+
+package dupes
+
+import "runtime"
+
+// DefaultConcurrency is the number of files hashed at once when
+// Options.Concurrency is unset: one per CPU.
+func DefaultConcurrency() int {
+	return runtime.NumCPU()
+}
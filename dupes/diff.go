@@ -0,0 +1,154 @@
+package dupes
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ============================================================================
+// MERKLE DIFF
+// ============================================================================
+
+// DiffRange is a run of chunk positions where two files differ, with the
+// byte ranges those chunks cover in each file. A range past the end of the
+// shorter file is empty on that side (Start == End).
+type DiffRange struct {
+	FirstChunk int
+	LastChunk  int // exclusive
+	AStart     int64
+	AEnd       int64
+	BStart     int64
+	BEnd       int64
+}
+
+// spanNode is a Merkle node with the leaf positions it covers.
+type spanNode struct {
+	node  MerkleNode
+	start int
+	count int
+}
+
+// children splits n into its two subtrees. BuildMerkleTree only ever pairs
+// a node that is not the last of its level as a left child, and those are
+// all perfect, so the left count follows from the height of the left spine.
+func (n spanNode) children() (spanNode, spanNode) {
+	left := n.node.Children[0]
+	leftCount := 1
+	for node := left; !node.IsLeaf; node = node.Children[0] {
+		leftCount *= 2
+	}
+	return spanNode{left, n.start, leftCount},
+		spanNode{n.node.Children[1], n.start + leftCount, n.count - leftCount}
+}
+
+// MerkleDiff compares two files chunk position by chunk position and returns
+// the ranges that differ. It walks both Merkle trees from the root and skips
+// any subtree whose hash matches the subtree covering the same positions in
+// the other file, so near-identical files cost little more than their depth.
+func MerkleDiff(a, b FileTree) ([]DiffRange, error) {
+	if a.HashAlg != b.HashAlg {
+		return nil, fmt.Errorf("cannot diff %s (%s) against %s (%s)", a.Path, a.HashAlg, b.Path, b.HashAlg)
+	}
+	treeA, err := diffTree(a)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := diffTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both frontiers cover positions from 0 upwards, so their heads always
+	// start at the same position; split the larger head until they match
+	var differing [][2]int
+	mark := func(start, end int) {
+		if n := len(differing); n > 0 && differing[n-1][1] == start {
+			differing[n-1][1] = end
+			return
+		}
+		differing = append(differing, [2]int{start, end})
+	}
+
+	frontA := frontier(treeA, len(a.ChunkSizes))
+	frontB := frontier(treeB, len(b.ChunkSizes))
+	for len(frontA) > 0 && len(frontB) > 0 {
+		x, y := frontA[0], frontB[0]
+		switch {
+		case x.count == y.count && bytes.Equal(x.node.Hash, y.node.Hash):
+			frontA, frontB = frontA[1:], frontB[1:]
+		case x.count == 1 && y.count == 1:
+			mark(x.start, x.start+1)
+			frontA, frontB = frontA[1:], frontB[1:]
+		case x.count >= y.count && !x.node.IsLeaf:
+			left, right := x.children()
+			frontA = append([]spanNode{left, right}, frontA[1:]...)
+		default:
+			left, right := y.children()
+			frontB = append([]spanNode{left, right}, frontB[1:]...)
+		}
+	}
+	for _, rest := range [][]spanNode{frontA, frontB} {
+		for _, n := range rest {
+			mark(n.start, n.start+n.count)
+		}
+	}
+
+	offsetA, offsetB := chunkBoundary(a), chunkBoundary(b)
+	return Map(differing, func(r [2]int) DiffRange {
+		return DiffRange{
+			FirstChunk: r[0],
+			LastChunk:  r[1],
+			AStart:     offsetA(r[0]),
+			AEnd:       offsetA(r[1]),
+			BStart:     offsetB(r[0]),
+			BEnd:       offsetB(r[1]),
+		}
+	}), nil
+}
+
+// chunkBoundary returns the byte offset where chunk i of ft starts, clamped
+// to the end of the file for positions past its last chunk.
+func chunkBoundary(ft FileTree) func(i int) int64 {
+	offsets := chunkOffsets(ft)
+	end := int64(0)
+	if n := len(offsets); n > 0 {
+		end = offsets[n-1] + int64(ft.ChunkSizes[n-1])
+	}
+	return func(i int) int64 {
+		if i < len(offsets) {
+			return offsets[i]
+		}
+		return end
+	}
+}
+
+// frontier starts a walk at the root. Empty files have no chunks, only the
+// placeholder leaf BuildMerkleTree gives them, so there is nothing to walk.
+func frontier(root MerkleNode, chunks int) []spanNode {
+	if chunks == 0 {
+		return nil
+	}
+	return []spanNode{{root, 0, chunks}}
+}
+
+// diffTree returns ft's Merkle tree, rebuilding it from the leaves if the
+// record was restored without one, and checks byte offsets can be derived.
+func diffTree(ft FileTree) (MerkleNode, error) {
+	if ft.Stage != StageFull {
+		return MerkleNode{}, fmt.Errorf("%s was not fully hashed", ft.Path)
+	}
+	if len(ft.ChunkSizes) == 0 && ft.Size == 0 {
+		return MerkleNode{}, nil
+	}
+	if len(ft.ChunkSizes) != len(ft.Leaves) {
+		return MerkleNode{}, fmt.Errorf("%s has no chunk sizes", ft.Path)
+	}
+	if len(ft.Tree.Hash) > 0 {
+		return ft.Tree, nil
+	}
+	hash, err := LookupHashAlgorithm(ft.HashAlg)
+	if err != nil {
+		return MerkleNode{}, err
+	}
+	return rebuildTree(ft.Leaves, hash)
+}
@@ -0,0 +1,83 @@
+package dupes
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// bruteDiff compares leaves position by position.
+func bruteDiff(a, b FileTree) [][2]int {
+	var ranges [][2]int
+	for i := 0; i < max(len(a.Leaves), len(b.Leaves)); i++ {
+		if i < len(a.Leaves) && i < len(b.Leaves) && a.Leaves[i] == b.Leaves[i] {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == i {
+			ranges[n-1][1] = i + 1
+		} else {
+			ranges = append(ranges, [2]int{i, i + 1})
+		}
+	}
+	return ranges
+}
+
+func TestMerkleDiffMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() []int {
+		ids := make([]int, rng.Intn(40))
+		for i := range ids {
+			ids[i] = rng.Intn(4)
+		}
+		return ids
+	}
+	for i := 0; i < 500; i++ {
+		idsA := random()
+		// mostly similar files, so whole subtrees get skipped
+		idsB := append([]int{}, idsA...)
+		for j := range idsB {
+			if rng.Intn(8) == 0 {
+				idsB[j] = rng.Intn(4)
+			}
+		}
+		tail := random()
+		idsB = append(idsB[:rng.Intn(len(idsB)+1)], tail[:min(len(tail), rng.Intn(3))]...)
+
+		_, a := chunkFile(t, "a", idsA...)
+		_, b := chunkFile(t, "b", idsB...)
+		if i%2 == 0 {
+			a.Tree = MerkleNode{} // as restored from a signature without its tree
+		}
+		got, err := MerkleDiff(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := Map(got, func(r DiffRange) [2]int { return [2]int{r.FirstChunk, r.LastChunk} })
+		if want := bruteDiff(a, b); len(chunks)+len(want) > 0 && !reflect.DeepEqual(chunks, want) {
+			t.Fatalf("MerkleDiff(%v, %v) = %v, want %v", idsA, idsB, chunks, want)
+		}
+	}
+}
+
+func TestMerkleDiffByteRanges(t *testing.T) {
+	_, a := chunkFile(t, "a", 1, 2, 3, 4, 5)
+	_, b := chunkFile(t, "b", 1, 9, 3)
+
+	got, err := MerkleDiff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffRange{
+		{FirstChunk: 1, LastChunk: 2, AStart: 16, AEnd: 32, BStart: 16, BEnd: 32},
+		// past the end of b: empty on b's side
+		{FirstChunk: 3, LastChunk: 5, AStart: 48, AEnd: 80, BStart: 48, BEnd: 48},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MerkleDiff = %+v, want %+v", got, want)
+	}
+
+	a.HashAlg = "blake2b"
+	if _, err := MerkleDiff(a, b); err == nil {
+		t.Error("diffed trees built with different hashes")
+	}
+}
@@ -0,0 +1,1135 @@
+// Package dupes finds exact, partial and visual duplicates with Merkle trees
+// over content chunks. It has no platform dependencies so the WASM module,
+// the MCP server and the command-line tool all share it.
+package dupes
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PROGRESS REPORTING
+// ============================================================================
+
+// ProgressFunc receives analysis progress; percent runs from 0 to 100.
+type ProgressFunc func(current, total int, message string, percent float64)
+
+func (o Options) reportProgress(current, total int, message string, percent float64) {
+	if o.Progress != nil {
+		o.Progress(current, total, message, percent)
+	}
+}
+
+func (o Options) reportError(path string, err error) {
+	if o.OnError != nil {
+		o.OnError(path, err)
+	}
+}
+
+// ============================================================================
+// MONOID
+// ============================================================================
+
+type Monoid[A any] struct {
+	Empty   func() A
+	Combine func(A, A) A
+}
+
+func (m Monoid[A]) Fold(xs []A) A {
+	return FoldLeft(xs, m.Empty(), m.Combine)
+}
+
+var SHA256Monoid = Monoid[[]byte]{
+	Empty: func() []byte { return []byte{} },
+	Combine: func(a, b []byte) []byte {
+		h := sha256.New()
+		h.Write([]byte{innerPrefix})
+		h.Write(a)
+		h.Write(b)
+		return h.Sum(nil)
+	},
+}
+
+func MapMonoid[K comparable, V any](vm Monoid[V]) Monoid[map[K]V] {
+	return Monoid[map[K]V]{
+		Empty: func() map[K]V { return make(map[K]V) },
+		Combine: func(a, b map[K]V) map[K]V {
+			result := make(map[K]V)
+			for k, v := range a {
+				result[k] = v
+			}
+			for k, v := range b {
+				if existing, ok := result[k]; ok {
+					result[k] = vm.Combine(existing, v)
+				} else {
+					result[k] = v
+				}
+			}
+			return result
+		},
+	}
+}
+
+func SliceMonoid[A any]() Monoid[[]A] {
+	return Monoid[[]A]{
+		Empty: func() []A { return []A{} },
+		Combine: func(a, b []A) []A {
+			result := make([]A, 0, len(a)+len(b))
+			result = append(result, a...)
+			result = append(result, b...)
+			return result
+		},
+	}
+}
+
+// ============================================================================
+// FOLD OPERATIONS
+// ============================================================================
+
+func FoldLeft[A, B any](xs []A, zero B, f func(B, A) B) B {
+	acc := zero
+	for _, x := range xs {
+		acc = f(acc, x)
+	}
+	return acc
+}
+
+func FoldRight[A, B any](xs []A, zero B, f func(A, B) B) B {
+	acc := zero
+	for i := len(xs) - 1; i >= 0; i-- {
+		acc = f(xs[i], acc)
+	}
+	return acc
+}
+
+func FoldMap[A, B any](xs []A, m Monoid[B], f func(A) B) B {
+	return m.Fold(Map(xs, f))
+}
+
+// ============================================================================
+// FUNCTOR INSTANCES
+// ============================================================================
+
+type Maybe[A any] struct {
+	value   A
+	present bool
+}
+
+func Just[A any](v A) Maybe[A] {
+	return Maybe[A]{value: v, present: true}
+}
+
+func Nothing[A any]() Maybe[A] {
+	return Maybe[A]{present: false}
+}
+
+func (m Maybe[A]) FMap(f func(A) A) Maybe[A] {
+	if !m.present {
+		return Nothing[A]()
+	}
+	return Just(f(m.value))
+}
+
+func (m Maybe[A]) IsPresent() bool {
+	return m.present
+}
+
+func (m Maybe[A]) Get() A {
+	return m.value
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// Map and Filter append to one growing slice; prepending in a FoldRight
+// copies the accumulator on every element and is quadratic.
+func Map[A, B any](xs []A, f func(A) B) []B {
+	return FoldLeft(xs, make([]B, 0, len(xs)), func(acc []B, a A) []B {
+		return append(acc, f(a))
+	})
+}
+
+// MapIndexed is Map with each element's index.
+func MapIndexed[A, B any](xs []A, f func(int, A) B) []B {
+	result := make([]B, len(xs))
+	for i, x := range xs {
+		result[i] = f(i, x)
+	}
+	return result
+}
+
+func Filter[A any](xs []A, pred func(A) bool) []A {
+	return FoldLeft(xs, []A{}, func(acc []A, a A) []A {
+		if pred(a) {
+			return append(acc, a)
+		}
+		return acc
+	})
+}
+
+func GroupBy[A any, K comparable](xs []A, key func(A) K) map[K][]A {
+	return FoldLeft(xs, make(map[K][]A), func(acc map[K][]A, x A) map[K][]A {
+		k := key(x)
+		acc[k] = append(acc[k], x)
+		return acc
+	})
+}
+
+// ============================================================================
+// DOMAIN TYPES
+// ============================================================================
+
+type MerkleNode struct {
+	Hash     []byte
+	Children []MerkleNode
+	IsLeaf   bool
+}
+
+type FileTree struct {
+	Path       string
+	Root       []byte
+	Tree       MerkleNode
+	Size       int64
+	ChunkCount int
+	Leaves     []string
+	ChunkSizes []int // Byte length of each leaf, in leaf order
+	LowEntropy []int // Indices of leaves under LowEntropyBits bits per byte
+	ModTime    int64
+	HashAlg    string      // Algorithm that produced Root and Leaves
+	Chunker    ChunkerSpec // Chunker that cut the leaves; zero unless fully hashed
+	Stage      string      // How far hashing went: StageSize, StagePartial, StageFull or StageError
+	PHash      uint64      // Phase 2: Image perceptual hash
+	IsImage    bool        // Phase 2: Is this an image file?
+	VideoHash  []uint64    // Phase 2: Video frame hashes (array of pHashes)
+	IsVideo    bool        // Phase 2: Is this a video file?
+}
+
+type DuplicateMatch struct {
+	TargetPath string
+	Similarity float64
+	SharedSize int64
+	MatchType  string // "exact", "partial", "content"
+
+	// Scores holds every chunk metric behind a partial match; Similarity is
+	// the one the run selected. Nil for root and perceptual hash matches.
+	Scores *SimilarityScores
+}
+
+type FileNode struct {
+	Path         string
+	Name         string
+	IsDir        bool
+	Children     []FileNode
+	Matches      []DuplicateMatch
+	BestMatch    float64
+	Size         int64
+	RelativePath string
+}
+
+type DuplicateGroup struct {
+	Files      []string
+	Similarity float64 // average over the matches between members
+	Size       int64
+	GroupType  string // "exact", "similar", "visual"
+	Savings    int64
+
+	MinSimilarity float64
+	MaxSimilarity float64
+}
+
+type DedupResult struct {
+	RootTree        FileNode
+	AllMatches      map[string][]DuplicateMatch
+	DuplicateGroups []DuplicateGroup
+	TotalFiles      int
+	UniqueFiles     int
+	FullDupCount    int
+	PartialDupCount int
+	VisualDupCount  int              // Phase 2: Visual duplicate count
+	SpaceSaved      int64            // bytes freed by keeping one copy of each exact group
+	Savings         SavingsBreakdown // reclaimable bytes per group type
+	ProcessingTime  float64
+	Chunker         ChunkerSpec
+	HashAlgorithm   string
+	Metric          string // SimilarityMetric partial matches were scored on
+	PrefilterSkips  int    // Files ruled out by size or head/tail hash before full hashing
+	CachedFiles     int    // Files taken from cached FileTrees instead of being hashed
+	StopChunks      []StopChunk
+}
+
+// Options controls a FindDuplicates run.
+type Options struct {
+	Threshold float64 // minimum similarity for partial matches
+	Chunker   Chunker
+	Hash      HashAlgorithm
+	Metric    SimilarityMetric // what partial matches are scored on; zero is overlap
+
+	// StopChunks are left out of partial matching; see StopChunkOptions.
+	StopChunks StopChunkOptions
+
+	// Linkage clusters partial and visual matches into groups; "" is single.
+	Linkage Linkage
+
+	// VisualSavings is how visual groups count towards savings; "" keeps
+	// the largest file.
+	VisualSavings VisualSavings
+
+	// PrefilterBytes enables the size and head/tail hash stages when positive.
+	// Partial matching needs the chunk leaves of every file, so the two are
+	// mutually exclusive: AnalyzeIncremental rejects PrefilterBytes unless
+	// SkipPartial is set.
+	PrefilterBytes int
+	SkipPartial    bool
+
+	// Concurrency is how many files are hashed at once; 0 means
+	// DefaultConcurrency(). Results are identical whatever the value.
+	Concurrency int
+
+	Progress ProgressFunc
+
+	// OnError, when set, hears about files whose content could not be read.
+	// They stay in the results at StageError and never match anything.
+	OnError func(path string, err error)
+}
+
+func DefaultOptions() Options {
+	hash, _ := LookupHashAlgorithm("sha256")
+	metric, _ := LookupSimilarityMetric("overlap")
+	return Options{
+		Threshold: 0.8,
+		Chunker:   FixedChunker{Size: 4096},
+		Hash:      hash,
+		Metric:    metric,
+
+		StopChunks: StopChunkOptions{LowEntropy: true},
+	}
+}
+
+type JSFile struct {
+	Name             string
+	Path             string
+	Size             int64
+	Data             []byte
+	Open             func() (io.ReadCloser, error) // Streams the content instead of Data when set
+	ModTime          int64
+	Inode            uint64   // Set by native walks; 0 when unknown
+	VideoFrameHashes []uint64 // Phase 2: Video frame hashes from JavaScript
+}
+
+// ============================================================================
+// MERKLE TREE
+// ============================================================================
+
+func HashLeaf(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// BuildMerkleTree pairs nodes level by level until one is left. An odd node
+// at the end of a level is carried up unchanged, so every inner node has
+// exactly two children and the tree mirrors how the root hash is computed.
+func BuildMerkleTree(hashes [][]byte, m Monoid[[]byte]) MerkleNode {
+	if len(hashes) == 0 {
+		return MerkleNode{Hash: m.Empty(), IsLeaf: true, Children: []MerkleNode{}}
+	}
+
+	level := Map(hashes, func(h []byte) MerkleNode {
+		return MerkleNode{Hash: h, IsLeaf: true, Children: []MerkleNode{}}
+	})
+	for len(level) > 1 {
+		level = pairwiseFold(level, m)
+	}
+	return level[0]
+}
+
+func pairwiseFold(nodes []MerkleNode, m Monoid[[]byte]) []MerkleNode {
+	type Acc struct {
+		nodes   []MerkleNode
+		pending Maybe[MerkleNode]
+	}
+
+	result := FoldLeft(nodes, Acc{nodes: []MerkleNode{}, pending: Nothing[MerkleNode]()},
+		func(acc Acc, node MerkleNode) Acc {
+			if !acc.pending.IsPresent() {
+				return Acc{nodes: acc.nodes, pending: Just(node)}
+			}
+
+			left := acc.pending.Get()
+			parent := MerkleNode{
+				Hash:     m.Combine(left.Hash, node.Hash),
+				IsLeaf:   false,
+				Children: []MerkleNode{left, node},
+			}
+
+			return Acc{nodes: append(acc.nodes, parent), pending: Nothing[MerkleNode]()}
+		})
+
+	if result.pending.IsPresent() {
+		return append(result.nodes, result.pending.Get())
+	}
+
+	return result.nodes
+}
+
+func collectLeaves(node MerkleNode) [][]byte {
+	if node.IsLeaf {
+		return [][]byte{node.Hash}
+	}
+
+	leafMonoid := SliceMonoid[[]byte]()
+	return FoldMap(node.Children, leafMonoid, collectLeaves)
+}
+
+// ============================================================================
+// FILE PROCESSING
+// ============================================================================
+
+// ProcessFile streams a file through the chunker, hashing each chunk as it
+// arrives. Only the leaf hashes and chunk sizes are kept; chunk content is
+// released as soon as it is hashed.
+func ProcessFile(file JSFile, chunker Chunker, hash HashAlgorithm) (FileTree, error) {
+	r, err := file.Reader()
+	if err != nil {
+		return FileTree{}, err
+	}
+	defer r.Close()
+
+	var content io.Reader = r
+	finishPHash := func(error) uint64 { return 0 }
+	if isImageFile(file.Path) {
+		content, finishPHash = teePHash(r)
+	}
+
+	hashes := [][]byte{}
+	chunkSizes := []int{}
+	var lowEntropy []int
+	readErr := streamChunks(content, chunker.Split(file.Path), func(chunk []byte) {
+		if chunkEntropy(chunk) < LowEntropyBits {
+			lowEntropy = append(lowEntropy, len(hashes))
+		}
+		hashes = append(hashes, hash.Leaf(chunk))
+		chunkSizes = append(chunkSizes, len(chunk))
+	})
+	pHash := finishPHash(readErr)
+	if readErr != nil {
+		return FileTree{}, readErr
+	}
+
+	tree := BuildMerkleTree(hashes, hash.Combine)
+	root := tree.Hash
+
+	leafBytes := collectLeaves(tree)
+	leaves := Map(leafBytes, func(b []byte) string {
+		return hex.EncodeToString(b)
+	})
+
+	media := mediaHashes(file, pHash)
+
+	return FileTree{
+		Path:       file.Path,
+		Root:       root,
+		Tree:       tree,
+		Size:       file.Size,
+		ChunkCount: len(hashes),
+		Leaves:     leaves,
+		ChunkSizes: chunkSizes,
+		LowEntropy: lowEntropy,
+		ModTime:    file.ModTime,
+		HashAlg:    hash.Name,
+		Chunker:    chunker.Spec(),
+		Stage:      StageFull,
+		PHash:      media.pHash,
+		IsImage:    media.isImage,
+		VideoHash:  media.videoHash,
+		IsVideo:    media.isVideo,
+	}, nil
+}
+
+type mediaInfo struct {
+	pHash     uint64
+	isImage   bool
+	videoHash []uint64
+	isVideo   bool
+}
+
+// mediaHashes gathers the Phase 2 fields; pHash was computed by the caller
+// while the content was read.
+func mediaHashes(file JSFile, pHash uint64) mediaInfo {
+	isImage := isImageFile(file.Path)
+	if !isImage {
+		pHash = 0
+	}
+
+	// Phase 2: Video frame hashes (computed by JavaScript)
+	var videoHash []uint64
+	isVideo := isVideoFile(file.Path)
+	if isVideo && len(file.VideoFrameHashes) > 0 {
+		videoHash = file.VideoFrameHashes
+	}
+
+	return mediaInfo{pHash: pHash, isImage: isImage, videoHash: videoHash, isVideo: isVideo}
+}
+
+// ============================================================================
+// DEDUPLICATION
+// ============================================================================
+
+// BuildChunkIndex maps each chunk hash to the indices of the files that
+// contain it, in one pass over the leaves. Each file appears at most once per
+// chunk and lists are in file order. Most chunks belong to a single file, so
+// their one-element lists are carved out of a shared arena instead of being
+// allocated one by one.
+func BuildChunkIndex(files []FileTree) map[string][]int {
+	totalLeaves := FoldLeft(files, 0, func(acc int, ft FileTree) int { return acc + len(ft.Leaves) })
+
+	index := make(map[string][]int, totalLeaves)
+	arena := make([]int, totalLeaves)
+
+	for fileIdx, ft := range files {
+		for _, chunkHash := range ft.Leaves {
+			postings, exists := index[chunkHash]
+			switch {
+			case !exists:
+				// Capacity 1 so a second file's append copies out of the arena
+				arena[0] = fileIdx
+				index[chunkHash] = arena[:1:1]
+				arena = arena[1:]
+			case postings[len(postings)-1] != fileIdx:
+				index[chunkHash] = append(postings, fileIdx)
+			}
+		}
+	}
+
+	return index
+}
+
+func FindCandidates(sourceFile FileTree, chunkIndex map[string][]int, threshold float64) map[int]int {
+	countMap := FoldLeft(sourceFile.Leaves, make(map[int]int),
+		func(acc map[int]int, chunkHash string) map[int]int {
+			if targets, exists := chunkIndex[chunkHash]; exists {
+				for _, targetIdx := range targets {
+					acc[targetIdx]++
+				}
+			}
+			return acc
+		})
+
+	minSharedChunks := int(float64(len(sourceFile.Leaves)) * threshold)
+
+	return FoldLeft(mapToSlice(countMap), make(map[int]int),
+		func(acc map[int]int, pair struct {
+			k int
+			v int
+		}) map[int]int {
+			if pair.v >= minSharedChunks {
+				acc[pair.k] = pair.v
+			}
+			return acc
+		})
+}
+
+// mapToSlice returns m's entries sorted by key, so folds over maps come out
+// the same on every run.
+func mapToSlice[K cmp.Ordered, V any](m map[K]V) []struct {
+	k K
+	v V
+} {
+	result := make([]struct {
+		k K
+		v V
+	}, 0, len(m))
+	for k, v := range m {
+		result = append(result, struct {
+			k K
+			v V
+		}{k, v})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].k < result[j].k })
+	return result
+}
+
+// RootKey identifies a file's content; the algorithm prefix keeps roots from
+// different hash algorithms from ever grouping together. Files the prefilter
+// ruled out have no root and are keyed by path so they never group.
+func RootKey(ft FileTree) string {
+	if ft.Stage != StageFull {
+		return ft.Stage + ":" + ft.Path
+	}
+	return ft.HashAlg + ":" + hex.EncodeToString(ft.Root)
+}
+
+func CompareFiles(a, b FileTree) float64 {
+	if a.HashAlg != b.HashAlg {
+		return 0.0
+	}
+
+	if RootKey(a) == RootKey(b) {
+		return 1.0
+	}
+
+	if len(a.Leaves) == 0 || len(b.Leaves) == 0 {
+		return 0.0
+	}
+
+	setB := FoldLeft(b.Leaves, make(map[string]bool, len(b.Leaves)),
+		func(acc map[string]bool, leaf string) map[string]bool {
+			acc[leaf] = true
+			return acc
+		})
+
+	matches := FoldLeft(a.Leaves, 0, func(acc int, leaf string) int {
+		if setB[leaf] {
+			return acc + 1
+		}
+		return acc
+	})
+
+	return float64(matches) / float64(len(a.Leaves))
+}
+
+// ============================================================================
+// SMART DUPLICATE GROUPS
+// ============================================================================
+
+// CreateSmartGroups builds exact groups from shared roots, then clusters the
+// partial and visual matches into similar and visual groups under
+// opts.Linkage. Savings are keep-one for exact groups, the bytes chunk-level
+// dedup would save for similar groups, and set by opts.VisualSavings for
+// visual groups.
+func CreateSmartGroups(filesByRoot map[string][]FileTree, partialMatches map[string][]DuplicateMatch, visualMatches map[string][]DuplicateMatch, fileTrees []FileTree, opts Options) []DuplicateGroup {
+	groups := []DuplicateGroup{}
+	claimed := make(map[string]bool) // files whose bytes an exact or similar group counts
+
+	// Exact duplicate groups
+	for _, pair := range mapToSlice(filesByRoot) {
+		group := pair.v
+		if len(group) <= 1 {
+			continue
+		}
+		for _, ft := range group {
+			claimed[ft.Path] = true
+		}
+
+		groupFiles := Map(group, func(ft FileTree) string { return ft.Path })
+
+		// Same content, so keeping any one copy saves the rest
+		totalSize := FoldLeft(group, int64(0), func(acc int64, ft FileTree) int64 {
+			return acc + ft.Size
+		})
+		savings := totalSize - group[0].Size
+
+		groups = append(groups, DuplicateGroup{
+			Files:         groupFiles,
+			Similarity:    1.0,
+			Size:          totalSize,
+			GroupType:     "exact",
+			Savings:       savings,
+			MinSimilarity: 1.0,
+			MaxSimilarity: 1.0,
+		})
+	}
+
+	byPath := make(map[string]FileTree, len(fileTrees))
+	for _, ft := range fileTrees {
+		byPath[ft.Path] = ft
+	}
+	members := func(paths []string) []FileTree {
+		return Map(paths, func(path string) FileTree { return byPath[path] })
+	}
+	sizeOf := func(ft FileTree) int64 { return ft.Size }
+	sum := func(sizes []int64) int64 {
+		return FoldLeft(sizes, int64(0), func(acc, size int64) int64 { return acc + size })
+	}
+
+	// Partial duplicate groups
+	for _, c := range clusterMatches(partialMatches, opts.Linkage) {
+		trees := members(c.files)
+		for _, path := range c.files {
+			claimed[path] = true
+		}
+
+		groups = append(groups, DuplicateGroup{
+			Files:         c.files,
+			Similarity:    c.avg,
+			Size:          sum(Map(trees, sizeOf)),
+			GroupType:     "similar",
+			Savings:       chunkSavings(trees),
+			MinSimilarity: c.min,
+			MaxSimilarity: c.max,
+		})
+	}
+
+	// Phase 2: Visual duplicate groups
+	for _, c := range clusterMatches(visualMatches, opts.Linkage) {
+		trees := members(c.files)
+
+		groups = append(groups, DuplicateGroup{
+			Files:         c.files,
+			Similarity:    c.avg,
+			Size:          sum(Map(trees, sizeOf)),
+			GroupType:     "visual",
+			Savings:       visualSavings(trees, claimed, opts.VisualSavings),
+			MinSimilarity: c.min,
+			MaxSimilarity: c.max,
+		})
+	}
+
+	return groups
+}
+
+// ============================================================================
+// TREE BUILDING
+// ============================================================================
+
+func BuildFileTree(rootPath string, files []FileTree, matches map[string][]DuplicateMatch) FileNode {
+	root := FileNode{
+		Path:         rootPath,
+		Name:         filepath.Base(rootPath),
+		IsDir:        true,
+		Children:     []FileNode{},
+		RelativePath: "",
+	}
+
+	// Walk files in input order so children come out in a stable order
+	for _, ft := range files {
+		rel, _ := filepath.Rel(rootPath, ft.Path)
+		parts := strings.Split(rel, string(filepath.Separator))
+		addToTree(&root, parts, ft, matches, rootPath)
+	}
+
+	return root
+}
+
+// commonDir is the deepest directory holding every path, so the file tree
+// never needs ".." steps whatever order the files came in.
+func commonDir(paths []string) string {
+	within := func(dir, path string) bool {
+		switch {
+		case dir == ".":
+			return !filepath.IsAbs(path)
+		case strings.HasSuffix(dir, string(filepath.Separator)):
+			return strings.HasPrefix(path, dir)
+		default:
+			return strings.HasPrefix(path, dir+string(filepath.Separator))
+		}
+	}
+
+	dir := filepath.Dir(paths[0])
+	for _, path := range paths[1:] {
+		for !within(dir, path) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return dir
+			}
+			dir = parent
+		}
+	}
+	return dir
+}
+
+func addToTree(node *FileNode, parts []string, ft FileTree, matches map[string][]DuplicateMatch, rootPath string) {
+	if len(parts) == 0 {
+		return
+	}
+
+	if len(parts) == 1 {
+		fileMatches := matches[ft.Path]
+		bestMatch := FoldLeft(fileMatches, 0.0, func(acc float64, m DuplicateMatch) float64 {
+			if m.Similarity > acc {
+				return m.Similarity
+			}
+			return acc
+		})
+
+		node.Children = append(node.Children, FileNode{
+			Path:         ft.Path,
+			Name:         parts[0],
+			IsDir:        false,
+			Children:     []FileNode{},
+			Matches:      fileMatches,
+			BestMatch:    bestMatch,
+			Size:         ft.Size,
+			RelativePath: ft.Path,
+		})
+		return
+	}
+
+	dirName := parts[0]
+	dirNode := findOrCreateDir(node, dirName, rootPath)
+	addToTree(dirNode, parts[1:], ft, matches, rootPath)
+}
+
+func findOrCreateDir(node *FileNode, dirName string, rootPath string) *FileNode {
+	for i := range node.Children {
+		if node.Children[i].Name == dirName && node.Children[i].IsDir {
+			return &node.Children[i]
+		}
+	}
+
+	currentPath := filepath.Join(node.Path, dirName)
+	relativePath, _ := filepath.Rel(rootPath, currentPath)
+
+	node.Children = append(node.Children, FileNode{
+		Name:         dirName,
+		Path:         currentPath,
+		RelativePath: relativePath,
+		IsDir:        true,
+		Children:     []FileNode{},
+	})
+
+	return &node.Children[len(node.Children)-1]
+}
+
+// ============================================================================
+// MAIN DEDUPLICATION
+// ============================================================================
+
+func FindDuplicates(files []JSFile, opts Options) DedupResult {
+	result, _ := Analyze(files, opts)
+	return result
+}
+
+// Analyze is FindDuplicates that also returns the FileTree of every input
+// file, in input order, for callers that look files up afterwards.
+func Analyze(files []JSFile, opts Options) (DedupResult, []FileTree) {
+	result, fileTrees, _ := AnalyzeContext(context.Background(), files, opts)
+	return result, fileTrees
+}
+
+// AnalyzeContext is Analyze that stops early with ctx's error once ctx is
+// cancelled.
+func AnalyzeContext(ctx context.Context, files []JSFile, opts Options) (DedupResult, []FileTree, error) {
+	return AnalyzeIncremental(ctx, files, nil, opts)
+}
+
+// AnalyzeIncremental is AnalyzeContext with FileTrees kept from an earlier
+// run. A file whose cached tree has its path, size and modification time and
+// was fully hashed with opts.Hash and opts.Chunker skips hashing and only
+// goes through grouping and matching. Other cached trees, including those
+// whose leaves no longer rebuild their Root, are ignored and their files
+// hashed again.
+// The FileTrees returned are those of the files that were hashed, in input
+// order, so callers can persist them for the next run.
+func AnalyzeIncremental(ctx context.Context, files []JSFile, cached []FileTree, opts Options) (DedupResult, []FileTree, error) {
+	startTime := time.Now()
+	opts.Progress = monotonicProgress(opts.Progress)
+	if opts.Metric.Score == nil {
+		opts.Metric, _ = LookupSimilarityMetric("")
+	}
+	if _, err := ParseLinkage(string(opts.Linkage)); err != nil {
+		return DedupResult{}, nil, err
+	}
+	if _, err := ParseVisualSavings(string(opts.VisualSavings)); err != nil {
+		return DedupResult{}, nil, err
+	}
+	if opts.PrefilterBytes > 0 && !opts.SkipPartial {
+		return DedupResult{}, nil, errors.New("PrefilterBytes needs SkipPartial: partial matching hashes every file in full")
+	}
+
+	opts.reportProgress(0, 100, "Starting analysis...", 0)
+
+	files, cached = restoreCached(files, cached, opts.Hash, opts.Chunker.Spec())
+
+	// Process all files with progress
+	freshTrees, prefilterSkips, err := processFiles(ctx, files, cachedSizes(cached), opts)
+	if err != nil {
+		return DedupResult{}, nil, err
+	}
+	// Path order, cold or warm: which files come from the cache varies
+	// between runs, and groups, keep choices and the file tree must not
+	fileTrees := make([]FileTree, 0, len(freshTrees)+len(cached))
+	fileTrees = append(append(fileTrees, freshTrees...), cached...)
+	sort.SliceStable(fileTrees, func(i, j int) bool { return fileTrees[i].Path < fileTrees[j].Path })
+
+	opts.reportProgress(30, 100, "Grouping files...", 30)
+
+	// Group by merkle root
+	filesByRoot := GroupBy(fileTrees, RootKey)
+
+	opts.reportProgress(50, 100, "Finding exact duplicates...", 50)
+
+	// Process duplicates
+	exactDups := processExactDuplicates(filesByRoot)
+
+	opts.reportProgress(70, 100, "Finding similar files...", 70)
+
+	partialDups := PartialDupsResult{allMatches: make(map[string][]DuplicateMatch)}
+	if !opts.SkipPartial {
+		partialDups = processPartialDuplicates(fileTrees, exactDups.allMatches, opts)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return DedupResult{}, nil, err
+	}
+
+	opts.reportProgress(80, 100, "Finding visually similar images...", 80)
+
+	// Phase 2: Visual duplicates (optimized - skip exact matches)
+	// Build set of files already in exact duplicate groups
+	filesInExactGroups := make(map[string]bool)
+	for _, group := range filesByRoot {
+		if len(group) > 1 {
+			for _, ft := range group {
+				filesInExactGroups[ft.Path] = true
+			}
+		}
+	}
+
+	// Only check images NOT in exact duplicate groups
+	imagesToCheck := Filter(fileTrees, func(ft FileTree) bool {
+		return ft.IsImage && ft.PHash != 0 && !filesInExactGroups[ft.Path]
+	})
+
+	// Only check videos NOT in exact duplicate groups
+	videosToCheck := Filter(fileTrees, func(ft FileTree) bool {
+		return ft.IsVideo && len(ft.VideoHash) > 0 && !filesInExactGroups[ft.Path]
+	})
+
+	opts.reportProgress(81, 100, fmt.Sprintf("Checking %d images and %d videos for visual similarity...", len(imagesToCheck), len(videosToCheck)), 81)
+
+	// Combine images and videos for visual duplicate detection
+	mediaToCheck := append(imagesToCheck, videosToCheck...)
+	visualDups := findVisualDuplicates(mediaToCheck, 0.85)
+	visualCount := len(visualDups)
+
+	if err := ctx.Err(); err != nil {
+		return DedupResult{}, nil, err
+	}
+
+	opts.reportProgress(85, 100, "Creating smart groups...", 85)
+
+	// Smart groups (now includes visual matches)
+	smartGroups := CreateSmartGroups(filesByRoot, partialDups.allMatches, visualDups, fileTrees, opts)
+
+	opts.reportProgress(90, 100, "Building file tree...", 90)
+
+	// Combine results (Phase 1 + Phase 2)
+	allMatches := MapMonoid[string, []DuplicateMatch](SliceMonoid[DuplicateMatch]()).Combine(
+		exactDups.allMatches,
+		MapMonoid[string, []DuplicateMatch](SliceMonoid[DuplicateMatch]()).Combine(
+			partialDups.allMatches,
+			visualDups,
+		),
+	)
+
+	rootPath := "/"
+	if len(fileTrees) > 0 {
+		rootPath = commonDir(Map(fileTrees, func(ft FileTree) string { return ft.Path }))
+	}
+
+	tree := BuildFileTree(rootPath, fileTrees, allMatches)
+
+	totalFiles := len(fileTrees)
+	duplicateFileCount := exactDups.fullDupCount + partialDups.partialDupCount
+	uniqueCount := totalFiles - duplicateFileCount
+
+	processingTime := time.Since(startTime).Seconds()
+
+	opts.reportProgress(100, 100, "Analysis complete!", 100)
+
+	return DedupResult{
+		RootTree:        tree,
+		AllMatches:      allMatches,
+		DuplicateGroups: smartGroups,
+		TotalFiles:      totalFiles,
+		UniqueFiles:     uniqueCount,
+		FullDupCount:    exactDups.fullDupCount,
+		PartialDupCount: partialDups.partialDupCount,
+		VisualDupCount:  visualCount,
+		SpaceSaved:      exactDups.spaceSaved,
+		Savings:         savingsByType(smartGroups),
+		ProcessingTime:  processingTime,
+		Chunker:         opts.Chunker.Spec(),
+		HashAlgorithm:   opts.Hash.Name,
+		Metric:          opts.Metric.Name,
+		PrefilterSkips:  prefilterSkips,
+		CachedFiles:     len(cached),
+		StopChunks:      partialDups.stopChunks,
+	}, freshTrees, nil
+}
+
+type ExactDupsResult struct {
+	allMatches   map[string][]DuplicateMatch
+	groups       []DuplicateGroup
+	fullDupCount int
+	spaceSaved   int64
+}
+
+func processExactDuplicates(filesByRoot map[string][]FileTree) ExactDupsResult {
+	duplicateGroups := Filter(mapToSlice(filesByRoot),
+		func(pair struct {
+			k string
+			v []FileTree
+		}) bool {
+			return len(pair.v) > 1
+		})
+
+	type DupAcc struct {
+		matches map[string][]DuplicateMatch
+		groups  []DuplicateGroup
+		count   int
+		saved   int64
+	}
+
+	result := FoldLeft(duplicateGroups, DupAcc{
+		matches: make(map[string][]DuplicateMatch),
+		groups:  []DuplicateGroup{},
+		count:   0,
+		saved:   0,
+	}, func(acc DupAcc, pair struct {
+		k string
+		v []FileTree
+	}) DupAcc {
+		group := pair.v
+		groupFiles := Map(group, func(ft FileTree) string { return ft.Path })
+
+		acc.groups = append(acc.groups, DuplicateGroup{
+			Files:      groupFiles,
+			Similarity: 1.0,
+			Size:       group[0].Size,
+		})
+
+		// Every copy but the one kept can go
+		acc.saved += int64(len(group)-1) * group[0].Size
+
+		for _, src := range group {
+			matches := FoldLeft(group, []DuplicateMatch{},
+				func(macc []DuplicateMatch, tgt FileTree) []DuplicateMatch {
+					if src.Path != tgt.Path {
+						return append(macc, DuplicateMatch{
+							TargetPath: tgt.Path,
+							Similarity: 1.0,
+							SharedSize: src.Size,
+							MatchType:  "exact",
+						})
+					}
+					return macc
+				})
+
+			if len(matches) > 0 {
+				acc.matches[src.Path] = matches
+				acc.count++
+			}
+		}
+
+		return acc
+	})
+
+	return ExactDupsResult{
+		allMatches:   result.matches,
+		groups:       result.groups,
+		fullDupCount: result.count,
+		spaceSaved:   result.saved,
+	}
+}
+
+type PartialDupsResult struct {
+	allMatches      map[string][]DuplicateMatch
+	partialDupCount int
+	stopChunks      []StopChunk
+}
+
+// processPartialDuplicates matches every file outside an exact group against
+// the candidates sharing enough of its chunks, scored by opts.Metric. Stop
+// chunks are dropped from the index and from every file before scoring.
+// Candidates are found by overlap, which no other metric exceeds, so none
+// are missed.
+func processPartialDuplicates(fileTrees []FileTree, exactMatches map[string][]DuplicateMatch, opts Options) PartialDupsResult {
+	threshold, metric := opts.Threshold, opts.Metric
+	chunkIndex := BuildChunkIndex(fileTrees)
+
+	stopChunks := findStopChunks(fileTrees, chunkIndex, opts.StopChunks)
+	if len(stopChunks) > 0 {
+		stop := StopChunkSet(stopChunks)
+		for hash := range stop {
+			delete(chunkIndex, hash)
+		}
+		fileTrees = Map(fileTrees, func(ft FileTree) FileTree { return WithoutChunks(ft, stop) })
+	}
+
+	type FileWithIndex struct {
+		file  FileTree
+		index int
+	}
+
+	filesWithIndices := MapIndexed(fileTrees, func(idx int, ft FileTree) FileWithIndex {
+		return FileWithIndex{file: ft, index: idx}
+	})
+
+	candidateFiles := Filter(filesWithIndices, func(fwi FileWithIndex) bool {
+		_, hasExact := exactMatches[fwi.file.Path]
+		return !hasExact
+	})
+
+	type PartialAcc struct {
+		matches map[string][]DuplicateMatch
+		count   int
+	}
+
+	result := FoldLeft(candidateFiles, PartialAcc{
+		matches: make(map[string][]DuplicateMatch),
+		count:   0,
+	}, func(acc PartialAcc, fwi FileWithIndex) PartialAcc {
+		src := fwi.file
+		srcIdx := fwi.index
+
+		candidates := FindCandidates(src, chunkIndex, metric.CandidateThreshold(threshold))
+
+		matches := FoldLeft(mapToSlice(candidates), []DuplicateMatch{},
+			func(macc []DuplicateMatch, pair struct {
+				k int
+				v int
+			}) []DuplicateMatch {
+				targetIdx := pair.k
+				if targetIdx == srcIdx {
+					return macc
+				}
+
+				tgt := fileTrees[targetIdx]
+				if RootKey(src) == RootKey(tgt) {
+					return macc
+				}
+
+				scores := ScoreFiles(src, tgt)
+				similarity := metric.Of(scores)
+
+				if similarity >= threshold {
+					return append(macc, DuplicateMatch{
+						TargetPath: tgt.Path,
+						Similarity: similarity,
+						SharedSize: int64(float64(src.Size) * similarity),
+						MatchType:  "partial",
+						Scores:     &scores,
+					})
+				}
+
+				return macc
+			})
+
+		if len(matches) > 0 {
+			acc.matches[src.Path] = matches
+			acc.count++
+		}
+
+		return acc
+	})
+
+	return PartialDupsResult{
+		allMatches:      result.matches,
+		partialDupCount: result.count,
+		stopChunks:      stopChunks,
+	}
+}
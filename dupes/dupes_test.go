@@ -0,0 +1,23 @@
+package dupes
+
+import "testing"
+
+func TestCommonDir(t *testing.T) {
+	cases := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"/tmp/sc/a/b/x", "/tmp/sc/c/y", "/tmp/sc/z"}, "/tmp/sc"},
+		{[]string{"/tmp/sc/z", "/tmp/sc/a/b/x"}, "/tmp/sc"},
+		{[]string{"/tmp/sc/x", "/tmp/scx/y"}, "/tmp"},
+		{[]string{"/a/x", "/b/y"}, "/"},
+		{[]string{"/a/b/x"}, "/a/b"},
+		{[]string{"photos/a.jpg", "photos/2024/b.jpg"}, "photos"},
+		{[]string{"a/x", "b/y"}, "."},
+	}
+	for _, c := range cases {
+		if got := commonDir(c.paths); got != c.want {
+			t.Errorf("commonDir(%q) = %q, want %q", c.paths, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package dupes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// ============================================================================
+// HASH ALGORITHMS
+// ============================================================================
+
+// HashAlgorithm hashes chunk leaves and combines child hashes into Merkle
+// parents. Roots built with different algorithms are never comparable.
+// CollisionResistant algorithms can back inclusion proofs.
+type HashAlgorithm struct {
+	Name               string
+	Leaf               func([]byte) []byte
+	Combine            Monoid[[]byte]
+	CollisionResistant bool
+}
+
+// Leaves and inner nodes are hashed under different one-byte prefixes, as in
+// RFC 6962, so no inner node hash is also the hash of some chunk.
+const (
+	leafPrefix  byte = 0x00
+	innerPrefix byte = 0x01
+)
+
+var hashAlgorithms = map[string]HashAlgorithm{}
+
+func RegisterHashAlgorithm(alg HashAlgorithm) {
+	hashAlgorithms[alg.Name] = alg
+}
+
+func LookupHashAlgorithm(name string) (HashAlgorithm, error) {
+	if name == "" {
+		name = "sha256"
+	}
+	alg, ok := hashAlgorithms[name]
+	if !ok {
+		return HashAlgorithm{}, fmt.Errorf("unknown hash algorithm: %s (available: %v)", name, HashAlgorithmNames())
+	}
+	return alg, nil
+}
+
+func HashAlgorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prefixedLeaf builds a leaf hash from sum: leaf = H(0x00 || chunk).
+func prefixedLeaf(sum func([]byte) []byte) func([]byte) []byte {
+	return func(data []byte) []byte {
+		buf := make([]byte, 0, 1+len(data))
+		buf = append(buf, leafPrefix)
+		return sum(append(buf, data...))
+	}
+}
+
+// concatMonoid builds a Merkle combine from sum: parent = H(0x01 || left || right).
+func concatMonoid(sum func([]byte) []byte) Monoid[[]byte] {
+	return Monoid[[]byte]{
+		Empty: func() []byte { return []byte{} },
+		Combine: func(a, b []byte) []byte {
+			buf := make([]byte, 0, 1+len(a)+len(b))
+			buf = append(buf, innerPrefix)
+			buf = append(buf, a...)
+			buf = append(buf, b...)
+			return sum(buf)
+		},
+	}
+}
+
+func init() {
+	RegisterHashAlgorithm(HashAlgorithm{
+		Name:               "sha256",
+		Leaf:               HashLeaf,
+		Combine:            SHA256Monoid,
+		CollisionResistant: true,
+	})
+	RegisterHashAlgorithm(HashAlgorithm{
+		Name:               "blake2b",
+		Leaf:               prefixedLeaf(blake2b256),
+		Combine:            concatMonoid(blake2b256),
+		CollisionResistant: true,
+	})
+	// 64-bit and non-cryptographic: fine for a fast pre-pass, not for proofs
+	RegisterHashAlgorithm(HashAlgorithm{
+		Name:    "xxh64",
+		Leaf:    prefixedLeaf(xxh64Sum),
+		Combine: concatMonoid(xxh64Sum),
+	})
+}
+
+// ============================================================================
+// BLAKE2b-256
+// ============================================================================
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+func blake2b256(data []byte) []byte {
+	const blockSize = 128
+	const outLen = 32
+
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ outLen
+
+	var counter uint64
+	for len(data) > blockSize {
+		counter += blockSize
+		blake2bCompress(&h, data[:blockSize], counter, false)
+		data = data[blockSize:]
+	}
+
+	var last [blockSize]byte
+	copy(last[:], data)
+	counter += uint64(len(data))
+	blake2bCompress(&h, last[:], counter, true)
+
+	out := make([]byte, 64)
+	for i, v := range h {
+		binary.LittleEndian.PutUint64(out[i*8:], v)
+	}
+	return out[:outLen]
+}
+
+func blake2bCompress(h *[8]uint64, block []byte, counter uint64, final bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[i*8:])
+	}
+
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter
+	if final {
+		v[14] = ^v[14]
+	}
+
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] = v[a] + v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] = v[c] + v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] = v[a] + v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] = v[c] + v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+
+	for r := 0; r < 12; r++ {
+		s := &blake2bSigma[r%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// ============================================================================
+// XXH64
+// ============================================================================
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxh64Sum(data []byte) []byte {
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, xxh64(data, 0))
+	return out
+}
+
+func xxh64(data []byte, seed uint64) uint64 {
+	n := len(data)
+	var h uint64
+
+	if n >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(data) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(data[24:]))
+			data = data[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+
+	h += uint64(n)
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, lane uint64) uint64 {
+	acc += lane * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
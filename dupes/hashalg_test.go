@@ -0,0 +1,79 @@
+package dupes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// patterned returns n bytes counting up modulo 251.
+func patterned(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestBLAKE2b256KnownAnswers(t *testing.T) {
+	cases := []struct {
+		data []byte
+		want string
+	}{
+		{nil, "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{[]byte("abc"), "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		// exactly one block, then one byte into the second
+		{patterned(128), "c3582f71ebb2be66fa5dd750f80baae97554f3b015663c8be377cfcb2488c1d1"},
+		{patterned(129), "f7f3c46ba2564ff4c4c162da1f5b605f9f1c4aa6a20652a9f9a337c1a2f5b9c9"},
+	}
+	for _, c := range cases {
+		if got := hex.EncodeToString(blake2b256(c.data)); got != c.want {
+			t.Errorf("blake2b256(%d bytes) = %s, want %s", len(c.data), got, c.want)
+		}
+	}
+}
+
+func TestXXH64KnownAnswers(t *testing.T) {
+	cases := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		// long enough for the four-lane loop
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, c := range cases {
+		if got := xxh64([]byte(c.data), 0); got != c.want {
+			t.Errorf("xxh64(%q) = %#x, want %#x", c.data, got, c.want)
+		}
+	}
+}
+
+func TestRegisteredAlgorithmsPrefixTheirInputs(t *testing.T) {
+	sha := func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+	sums := map[string]func([]byte) []byte{
+		"sha256":  sha,
+		"blake2b": blake2b256,
+		"xxh64":   xxh64Sum,
+	}
+	left, right := []byte("left"), []byte("right")
+
+	for name, sum := range sums {
+		alg, err := LookupHashAlgorithm(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := alg.Leaf([]byte("chunk")), sum([]byte("\x00chunk")); !bytes.Equal(got, want) {
+			t.Errorf("%s leaf = %x, want H(0x00 || chunk) = %x", name, got, want)
+		}
+		if got, want := alg.Combine.Combine(left, right), sum([]byte("\x01leftright")); !bytes.Equal(got, want) {
+			t.Errorf("%s combine = %x, want H(0x01 || l || r) = %x", name, got, want)
+		}
+	}
+}
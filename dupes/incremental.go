@@ -0,0 +1,88 @@
+package dupes
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// ============================================================================
+// INCREMENTAL ANALYSIS
+// ============================================================================
+
+// restoreCached splits files into those that still need hashing and the
+// cached trees that stand in for the rest. A cached tree stands in for a file
+// when it has the file's path, size and modification time and restoreTree
+// accepts it for hash and chunker. Any other record is skipped and its file hashed again: records
+// outlive changes to the hashing scheme, and a stale one must not fail the run.
+func restoreCached(files []JSFile, cached []FileTree, hash HashAlgorithm, chunker ChunkerSpec) ([]JSFile, []FileTree) {
+	if len(cached) == 0 {
+		return files, nil
+	}
+
+	byPath := make(map[string]FileTree, len(cached))
+	for _, ft := range cached {
+		byPath[ft.Path] = ft
+	}
+
+	var toHash []JSFile
+	restored := make([]FileTree, 0, len(cached))
+	for _, f := range files {
+		ft, ok := byPath[f.Path]
+		if ok && ft.Size == f.Size && ft.ModTime == f.ModTime {
+			ft, ok = restoreTree(ft, hash, chunker)
+		} else {
+			ok = false
+		}
+		if ok {
+			restored = append(restored, ft)
+		} else {
+			toHash = append(toHash, f)
+		}
+	}
+	return toHash, restored
+}
+
+// restoreTree accepts a fully hashed tree built with hash and chunker,
+// rebuilding its Merkle tree if it was stored with only its Root and Leaves.
+// It rejects the tree when the leaves do not rebuild to its Root.
+func restoreTree(ft FileTree, hash HashAlgorithm, chunker ChunkerSpec) (FileTree, bool) {
+	if ft.Stage == "" && len(ft.Root) > 0 {
+		ft.Stage = StageFull
+	}
+	if ft.Stage != StageFull || ft.HashAlg != hash.Name || ft.Chunker != chunker {
+		return ft, false
+	}
+	if len(ft.Tree.Hash) == 0 {
+		tree, err := rebuildTree(ft.Leaves, hash)
+		if err != nil || !bytes.Equal(tree.Hash, ft.Root) {
+			return ft, false
+		}
+		ft.Tree = tree
+	}
+	return ft, true
+}
+
+// rebuildTree recomputes a Merkle tree from hex leaf hashes.
+func rebuildTree(leaves []string, hash HashAlgorithm) (MerkleNode, error) {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		b, err := hex.DecodeString(leaf)
+		if err != nil {
+			return MerkleNode{}, fmt.Errorf("leaf %d: %v", i, err)
+		}
+		hashes[i] = b
+	}
+	return BuildMerkleTree(hashes, hash.Combine), nil
+}
+
+// cachedSizes is the set of sizes of fully hashed cached trees.
+func cachedSizes(cached []FileTree) map[int64]bool {
+	sizes := make(map[int64]bool, len(cached))
+	for _, ft := range cached {
+		if ft.Stage == StageFull {
+			sizes[ft.Size] = true
+		}
+	}
+	return sizes
+}
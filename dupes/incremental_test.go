@@ -0,0 +1,88 @@
+package dupes
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeIncrementalMatchesColdRun(t *testing.T) {
+	shared := strings.Repeat("shared block of text ", 400)
+	contents := map[string]string{
+		"/d/z/copy": shared,
+		"/d/a/orig": shared,
+		"/d/m/edit": shared[:len(shared)-200] + strings.Repeat("x", 200),
+		"/d/b/solo": strings.Repeat("unrelated ", 50),
+	}
+	// Walk order deliberately not path order
+	order := []string{"/d/z/copy", "/d/m/edit", "/d/a/orig", "/d/b/solo"}
+	files := Map(order, func(path string) JSFile {
+		return JSFile{Path: path, Size: int64(len(contents[path])), Data: []byte(contents[path])}
+	})
+
+	opts := DefaultOptions()
+	opts.Chunker = FixedChunker{Size: 256}
+
+	cold, trees, err := AnalyzeIncremental(context.Background(), files, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warm, hashed, err := AnalyzeIncremental(context.Background(), files, trees[2:], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cold.DuplicateGroups, warm.DuplicateGroups) {
+		t.Errorf("groups differ:\ncold %+v\nwarm %+v", cold.DuplicateGroups, warm.DuplicateGroups)
+	}
+	if !reflect.DeepEqual(cold.RootTree, warm.RootTree) {
+		t.Errorf("file trees differ:\ncold %+v\nwarm %+v", cold.RootTree, warm.RootTree)
+	}
+	if warm.CachedFiles != 2 {
+		t.Errorf("CachedFiles = %d, want 2", warm.CachedFiles)
+	}
+	if got := Map(hashed, func(ft FileTree) string { return ft.Path }); !reflect.DeepEqual(got, order[:2]) {
+		t.Errorf("hashed %v, want %v", got, order[:2])
+	}
+}
+
+func TestAnalyzeIncrementalRehashesUnusableTrees(t *testing.T) {
+	files := Map([]string{"/d/a", "/d/b", "/d/c", "/d/d", "/d/e", "/d/f"}, func(path string) JSFile {
+		content := strings.Repeat(path+" content ", 300)
+		return JSFile{Path: path, Size: int64(len(content)), ModTime: 1, Data: []byte(content)}
+	})
+	opts := DefaultOptions()
+	opts.Chunker = FixedChunker{Size: 256}
+
+	_, trees, err := AnalyzeIncremental(context.Background(), files, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As stored by the page: no Merkle tree, only Root and Leaves
+	for i := range trees {
+		trees[i].Tree = MerkleNode{}
+	}
+	trees[0].HashAlg = "xxh64"
+	trees[1].Leaves = append([]string{trees[1].Leaves[1]}, trees[1].Leaves[1:]...)
+	trees[2].ModTime = 2
+	trees[3].Stage = StageSize
+	trees[4].Chunker.Size = 512
+
+	result, hashed, err := AnalyzeIncremental(context.Background(), files, trees, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CachedFiles != 1 {
+		t.Errorf("CachedFiles = %d, want 1", result.CachedFiles)
+	}
+	want := []string{"/d/a", "/d/b", "/d/c", "/d/d", "/d/e"}
+	if got := Map(hashed, func(ft FileTree) string { return ft.Path }); !reflect.DeepEqual(got, want) {
+		t.Errorf("hashed %v, want %v", got, want)
+	}
+	for _, ft := range hashed {
+		if ft.HashAlg != opts.Hash.Name || ft.Stage != StageFull {
+			t.Errorf("%s: rehashed as %s/%s", ft.Path, ft.HashAlg, ft.Stage)
+		}
+	}
+}
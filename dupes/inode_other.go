@@ -0,0 +1,10 @@
+//go:build !unix
+
+package dupes
+
+import "os"
+
+// fileInode is always 0 where the platform has no inode numbers.
+func fileInode(os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package dupes
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode number behind info, or 0 if unavailable.
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -1,11 +1,11 @@
-package main
+package dupes
 
 import (
-	"bytes"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"math"
 	"path/filepath"
 	"strings"
@@ -109,9 +109,9 @@ func calculateMedian(values []float64) float64 {
 }
 
 // Compute pHash for an image
-func computePHash(imageData []byte) (uint64, error) {
+func computePHash(r io.Reader) (uint64, error) {
 	// Decode image
-	img, _, err := image.Decode(bytes.NewReader(imageData))
+	img, _, err := image.Decode(r)
 	if err != nil {
 		return 0, err
 	}
@@ -172,11 +172,24 @@ func hammingDistance(hash1, hash2 uint64) int {
 }
 
 // Convert Hamming distance to similarity percentage
-func hashSimilarity(hash1, hash2 uint64) float64 {
+func HashSimilarity(hash1, hash2 uint64) float64 {
 	distance := hammingDistance(hash1, hash2)
 	return 1.0 - (float64(distance) / 64.0)
 }
 
+// VisualSimilarity compares two files by perceptual hash, image to image or
+// video to video. Any other pairing scores 0.
+func VisualSimilarity(a, b FileTree) float64 {
+	switch {
+	case a.IsImage && b.IsImage && a.PHash != 0 && b.PHash != 0:
+		return HashSimilarity(a.PHash, b.PHash)
+	case a.IsVideo && b.IsVideo:
+		return videoHashSimilarity(a.VideoHash, b.VideoHash)
+	default:
+		return 0.0
+	}
+}
+
 // Compare video hashes (array of frame hashes)
 func videoHashSimilarity(video1Hashes, video2Hashes []uint64) float64 {
 	if len(video1Hashes) == 0 || len(video2Hashes) == 0 {
@@ -191,7 +204,7 @@ func videoHashSimilarity(video1Hashes, video2Hashes []uint64) float64 {
 	}
 
 	for i := 0; i < minLen; i++ {
-		similarity := hashSimilarity(video1Hashes[i], video2Hashes[i])
+		similarity := HashSimilarity(video1Hashes[i], video2Hashes[i])
 		if similarity >= 0.85 { // Frame is 85%+ similar
 			matches++
 		}
@@ -208,8 +221,6 @@ func videoHashSimilarity(video1Hashes, video2Hashes []uint64) float64 {
 
 // Find visually similar images and videos
 func findVisualDuplicates(files []FileTree, threshold float64) map[string][]DuplicateMatch {
-	reportProgress(0, 100, "Finding visually similar media...", 80)
-
 	// Separate images and videos
 	imageFiles := Filter(files, func(ft FileTree) bool {
 		return ft.IsImage && ft.PHash != 0
@@ -228,7 +239,7 @@ func findVisualDuplicates(files []FileTree, threshold float64) map[string][]Dupl
 				continue
 			}
 
-			similarity := hashSimilarity(src.PHash, tgt.PHash)
+			similarity := HashSimilarity(src.PHash, tgt.PHash)
 
 			if similarity >= threshold {
 				matches[src.Path] = append(matches[src.Path], DuplicateMatch{
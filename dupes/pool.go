@@ -0,0 +1,85 @@
+package dupes
+
+import (
+	"context"
+	"sync"
+)
+
+// ============================================================================
+// WORKER POOL
+// ============================================================================
+
+// forEachParallel calls fn(i) for every i in [0, n) on up to workers
+// goroutines. Callers write results into slot i of a preallocated slice, so
+// output order never depends on scheduling. It stops handing out work once
+// ctx is cancelled and returns ctx's error.
+func forEachParallel(ctx context.Context, n, workers int, fn func(i int)) error {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fn(i)
+		}
+		return nil
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	return err
+}
+
+// concurrency resolves Options.Concurrency: zero or less means the platform
+// default.
+func (o Options) concurrency() int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return DefaultConcurrency()
+}
+
+// monotonicProgress wraps fn so it is safe to call from several goroutines
+// and never reports a lower percentage than it already has.
+func monotonicProgress(fn ProgressFunc) ProgressFunc {
+	if fn == nil {
+		return nil
+	}
+
+	var mu sync.Mutex
+	last := -1.0
+	return func(current, total int, message string, percent float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if percent < last {
+			return
+		}
+		last = percent
+		fn(current, total, message, percent)
+	}
+}
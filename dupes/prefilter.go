@@ -0,0 +1,183 @@
+package dupes
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// ============================================================================
+// STAGED HASHING
+// ============================================================================
+
+// Hashing stages a FileTree can stop at. Only StageFull trees carry a Root,
+// leaves and a Merkle tree; the others were ruled out as exact duplicates
+// before their content was hashed.
+const (
+	StageSize    = "size"    // size is unique in the set
+	StagePartial = "partial" // head/tail hash is unique among same-size files
+	StageFull    = "full"
+	StageError   = "error" // content could not be read; see Options.OnError
+)
+
+// processFiles hashes files in stages: group by size, then by a hash of the
+// first and last PrefilterBytes, and only build full Merkle trees for files
+// that still collide. AnalyzeIncremental only allows this with partial
+// matching off, since that needs the chunk leaves of every file. Files
+// sharing a size with a cached tree are always fully hashed, since cached
+// trees only keep a Root to compare against. Files are hashed on
+// opts.concurrency() workers.
+func processFiles(ctx context.Context, files []JSFile, cachedSizes map[int64]bool, opts Options) ([]FileTree, int, error) {
+	needsFull := make([]bool, len(files))
+	stage := make([]string, len(files))
+
+	if opts.PrefilterBytes <= 0 {
+		for i := range needsFull {
+			needsFull[i] = true
+		}
+	} else {
+		opts.reportProgress(0, 100, "Prefiltering by size...", 0)
+		for i := range stage {
+			stage[i] = StageSize
+		}
+
+		bySize := GroupBy(indices(len(files)), func(i int) int64 { return files[i].Size })
+		for size, sameSize := range bySize {
+			if cachedSizes[size] {
+				for _, i := range sameSize {
+					needsFull[i] = true
+				}
+				continue
+			}
+			if len(sameSize) <= 1 {
+				continue
+			}
+
+			byEnds := GroupBy(sameSize, func(i int) string {
+				key, err := partialHash(files[i], opts.PrefilterBytes, opts.Hash)
+				if err != nil {
+					opts.reportError(files[i].Path, err)
+					stage[i] = StageError
+					return StageError + ":" + files[i].Path
+				}
+				return key
+			})
+			for _, sameEnds := range byEnds {
+				for _, i := range sameEnds {
+					if stage[i] == StageError {
+						continue
+					}
+					stage[i] = StagePartial
+					needsFull[i] = len(sameEnds) > 1
+				}
+			}
+		}
+	}
+
+	// Hashing is the first 30% of an analysis
+	fileTrees := make([]FileTree, len(files))
+	var mu sync.Mutex
+	done, skipped := 0, 0
+
+	err := forEachParallel(ctx, len(files), opts.concurrency(), func(i int) {
+		f := files[i]
+		message := fmt.Sprintf("Processing %s", f.Name)
+		var readErr error
+		if needsFull[i] {
+			ft, err := ProcessFile(f, opts.Chunker, opts.Hash)
+			if err != nil {
+				readErr = err
+				ft = prefilteredFile(f, opts.Hash, StageError)
+			}
+			fileTrees[i] = ft
+		} else {
+			message = fmt.Sprintf("Skipping %s (%s unique)", f.Name, stage[i])
+			fileTrees[i] = prefilteredFile(f, opts.Hash, stage[i])
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if readErr != nil {
+			opts.reportError(f.Path, readErr)
+		}
+		done++
+		if !needsFull[i] {
+			skipped++
+		}
+		opts.reportProgress(done, len(files), message, float64(done)/float64(len(files))*30)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return fileTrees, skipped, nil
+}
+
+// HashFiles builds the full FileTree of every file, in input order, without
+// grouping or matching them. Prefiltering is skipped since there is nothing
+// to rule files out against.
+func HashFiles(ctx context.Context, files []JSFile, opts Options) ([]FileTree, error) {
+	opts.PrefilterBytes = 0
+	if progress := monotonicProgress(opts.Progress); progress != nil {
+		// processFiles reports hashing as the first 30% of an analysis
+		opts.Progress = func(current, total int, message string, percent float64) {
+			progress(current, total, message, percent*100/30)
+		}
+	}
+
+	trees, _, err := processFiles(ctx, files, nil, opts)
+	return trees, err
+}
+
+// partialHash hashes the size together with the first and last n bytes.
+func partialHash(file JSFile, n int, hash HashAlgorithm) (string, error) {
+	r, err := file.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	head, tail, err := readEnds(r, file.Size, n)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 8, 8+len(head)+len(tail))
+	binary.BigEndian.PutUint64(buf, uint64(file.Size))
+	buf = append(buf, head...)
+	buf = append(buf, tail...)
+
+	return hex.EncodeToString(hash.Leaf(buf)), nil
+}
+
+// prefilteredFile builds the FileTree for a file that skipped full hashing.
+// Media hashes are still computed so visual matching sees every file.
+func prefilteredFile(file JSFile, hash HashAlgorithm, stage string) FileTree {
+	var pHash uint64
+	if isImageFile(file.Path) && stage != StageError {
+		pHash = readPHash(file)
+	}
+	media := mediaHashes(file, pHash)
+	return FileTree{
+		Path:      file.Path,
+		Size:      file.Size,
+		Leaves:    []string{},
+		ModTime:   file.ModTime,
+		HashAlg:   hash.Name,
+		Stage:     stage,
+		PHash:     media.pHash,
+		IsImage:   media.isImage,
+		VideoHash: media.videoHash,
+		IsVideo:   media.isVideo,
+	}
+}
+
+func indices(n int) []int {
+	result := make([]int, n)
+	for i := range result {
+		result[i] = i
+	}
+	return result
+}
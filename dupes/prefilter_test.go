@@ -0,0 +1,62 @@
+package dupes
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPrefilterOnlyHashesCollidingFiles(t *testing.T) {
+	body := strings.Repeat("0123456789abcdef", 1024)
+	edited := body[:8000] + "X" + body[8001:] // same size and ends as body
+	files := []JSFile{
+		{Path: "/p/a", Data: []byte(body)},
+		{Path: "/p/b", Data: []byte(body)},
+		{Path: "/p/c", Data: []byte(edited)},
+		{Path: "/p/d", Data: []byte("Z" + body[1:])}, // same size, different head
+		{Path: "/p/e", Data: []byte("short")},
+	}
+	for i := range files {
+		files[i].Size = int64(len(files[i].Data))
+	}
+
+	opts := DefaultOptions()
+	opts.PrefilterBytes = 64
+	opts.SkipPartial = true
+
+	result, trees, err := AnalyzeIncremental(context.Background(), files, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := map[string]string{}
+	for _, ft := range trees {
+		stages[ft.Path] = ft.Stage
+	}
+	want := map[string]string{
+		"/p/a": StageFull,
+		"/p/b": StageFull,
+		"/p/c": StageFull,
+		"/p/d": StagePartial,
+		"/p/e": StageSize,
+	}
+	for path, stage := range want {
+		if stages[path] != stage {
+			t.Errorf("%s: stage %q, want %q", path, stages[path], stage)
+		}
+	}
+	if result.PrefilterSkips != 2 {
+		t.Errorf("PrefilterSkips = %d, want 2", result.PrefilterSkips)
+	}
+	if len(result.DuplicateGroups) != 1 || strings.Join(result.DuplicateGroups[0].Files, ",") != "/p/a,/p/b" {
+		t.Errorf("groups = %+v, want one exact group of a and b", result.DuplicateGroups)
+	}
+}
+
+func TestPrefilterRejectedWithPartialMatching(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PrefilterBytes = 64
+	if _, _, err := AnalyzeIncremental(context.Background(), nil, nil, opts); err == nil {
+		t.Error("PrefilterBytes without SkipPartial was accepted")
+	}
+}
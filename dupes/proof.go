@@ -0,0 +1,158 @@
+package dupes
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ============================================================================
+// INCLUSION PROOFS
+// ============================================================================
+
+// InclusionProof shows that a chunk hash is leaf LeafIndex of a file's Merkle
+// tree without the rest of the leaves: hashing the leaf up through Siblings
+// must give the file's Root. Siblings run from the leaf upwards. The shape of
+// the tree is not part of the proof; the verifier takes it from the file it
+// already trusts. Hashes are hex, like FileTree.Leaves.
+type InclusionProof struct {
+	HashAlg   string   `json:"hash"`
+	LeafIndex int      `json:"leafIndex"`
+	Leaf      string   `json:"leaf"`
+	Siblings  []string `json:"siblings"`
+}
+
+// ProveChunk builds the inclusion proof for the first leaf of ft equal to
+// chunkHash (hex).
+func ProveChunk(ft FileTree, chunkHash string) (InclusionProof, error) {
+	for i, leaf := range ft.Leaves {
+		if leaf == chunkHash {
+			return ProveLeaf(ft, i)
+		}
+	}
+	return InclusionProof{}, fmt.Errorf("%s has no chunk %s", ft.Path, chunkHash)
+}
+
+// ProveLeaf builds the inclusion proof for leaf index of ft, walking its
+// Merkle tree from the root down. Trees restored without their MerkleNode
+// are rebuilt from the leaves first.
+func ProveLeaf(ft FileTree, index int) (InclusionProof, error) {
+	if ft.Stage != StageFull {
+		return InclusionProof{}, fmt.Errorf("%s was not fully hashed", ft.Path)
+	}
+	hash, err := proofHash(ft.HashAlg)
+	if err != nil {
+		return InclusionProof{}, err
+	}
+	if index < 0 || index >= len(ft.Leaves) {
+		return InclusionProof{}, fmt.Errorf("%s: leaf %d out of range (%d leaves)", ft.Path, index, len(ft.Leaves))
+	}
+
+	tree := ft.Tree
+	if len(tree.Hash) == 0 {
+		if tree, err = rebuildTree(ft.Leaves, hash); err != nil {
+			return InclusionProof{}, fmt.Errorf("%s: %v", ft.Path, err)
+		}
+	}
+
+	// Top-down, then reversed so siblings run leaf to root
+	siblings := []string{}
+	node, offset := tree, index
+	for !node.IsLeaf {
+		left, right := node.Children[0], node.Children[1]
+		if n := countLeaves(left); offset < n {
+			siblings = append(siblings, hex.EncodeToString(right.Hash))
+			node = left
+		} else {
+			siblings = append(siblings, hex.EncodeToString(left.Hash))
+			node, offset = right, offset-n
+		}
+	}
+	for i, j := 0, len(siblings)-1; i < j; i, j = i+1, j-1 {
+		siblings[i], siblings[j] = siblings[j], siblings[i]
+	}
+
+	return InclusionProof{
+		HashAlg:   hash.Name,
+		LeafIndex: index,
+		Leaf:      hex.EncodeToString(node.Hash),
+		Siblings:  siblings,
+	}, nil
+}
+
+// countLeaves is the number of leaves under node.
+func countLeaves(node MerkleNode) int {
+	if node.IsLeaf {
+		return 1
+	}
+	return FoldLeft(node.Children, 0, func(acc int, child MerkleNode) int {
+		return acc + countLeaves(child)
+	})
+}
+
+// proofHash looks up a hash algorithm that can back inclusion proofs.
+func proofHash(name string) (HashAlgorithm, error) {
+	hash, err := LookupHashAlgorithm(name)
+	if err != nil {
+		return HashAlgorithm{}, err
+	}
+	if !hash.CollisionResistant {
+		return HashAlgorithm{}, fmt.Errorf("%s is not collision resistant and cannot back proofs", hash.Name)
+	}
+	return hash, nil
+}
+
+// VerifyInclusion checks proof against trusted, a file the verifier already
+// holds, such as one decoded from a Signature. Root, hash algorithm and leaf
+// count all come from trusted, never from the proof, so the prover cannot
+// pick a tree shape that makes an inner node pass for a leaf. It replays the
+// level-by-level pairing of BuildMerkleTree: a node at an odd position has
+// its sibling on the left, and the last node of an odd-sized level is
+// carried up without one.
+func VerifyInclusion(trusted FileTree, proof InclusionProof) error {
+	hash, err := proofHash(trusted.HashAlg)
+	if err != nil {
+		return err
+	}
+	if proof.HashAlg != hash.Name {
+		return fmt.Errorf("proof uses %q, file was hashed with %s", proof.HashAlg, hash.Name)
+	}
+	if proof.LeafIndex < 0 || proof.LeafIndex >= trusted.ChunkCount {
+		return fmt.Errorf("leaf %d out of range (%d leaves)", proof.LeafIndex, trusted.ChunkCount)
+	}
+
+	current, err := hex.DecodeString(proof.Leaf)
+	if err != nil {
+		return fmt.Errorf("leaf: %v", err)
+	}
+
+	siblings := proof.Siblings
+	for i, n := proof.LeafIndex, trusted.ChunkCount; n > 1; i, n = i/2, (n+1)/2 {
+		if i%2 == 0 && i+1 == n {
+			continue
+		}
+		if len(siblings) == 0 {
+			return errors.New("proof is too short")
+		}
+		sibling, err := hex.DecodeString(siblings[0])
+		if err != nil {
+			return fmt.Errorf("sibling: %v", err)
+		}
+		siblings = siblings[1:]
+
+		if i%2 == 1 {
+			current = hash.Combine.Combine(sibling, current)
+		} else {
+			current = hash.Combine.Combine(current, sibling)
+		}
+	}
+
+	if len(siblings) > 0 {
+		return errors.New("proof is too long")
+	}
+	if !bytes.Equal(current, trusted.Root) {
+		return errors.New("proof does not lead to the root")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dupes
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// chunkedTree hashes n distinct 16-byte chunks with the named algorithm.
+func chunkedTree(t *testing.T, n int, alg string) FileTree {
+	t.Helper()
+	hash, err := LookupHashAlgorithm(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 0, n*16)
+	for i := 0; i < n; i++ {
+		data = append(data, fmt.Sprintf("chunk %09d\n", i)...)
+	}
+	ft, err := ProcessFile(JSFile{Path: "f", Size: int64(len(data)), Data: data}, FixedChunker{Size: 16}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ft.ChunkCount != n {
+		t.Fatalf("got %d chunks, want %d", ft.ChunkCount, n)
+	}
+	return ft
+}
+
+func TestInclusionProofRoundTrip(t *testing.T) {
+	for _, alg := range []string{"sha256", "blake2b"} {
+		for n := 1; n <= 17; n++ {
+			ft := chunkedTree(t, n, alg)
+			for i := 0; i < n; i++ {
+				proof, err := ProveLeaf(ft, i)
+				if err != nil {
+					t.Fatalf("%s n=%d i=%d: %v", alg, n, i, err)
+				}
+				if err := VerifyInclusion(ft, proof); err != nil {
+					t.Errorf("%s n=%d i=%d: %v", alg, n, i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestProveChunkRebuildsMissingTree(t *testing.T) {
+	ft := chunkedTree(t, 5, "sha256")
+	bare := ft
+	bare.Tree = MerkleNode{}
+
+	proof, err := ProveChunk(bare, ft.Leaves[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.LeafIndex != 3 {
+		t.Errorf("LeafIndex = %d, want 3", proof.LeafIndex)
+	}
+	if err := VerifyInclusion(ft, proof); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyInclusionRejectsForgeries(t *testing.T) {
+	ft := chunkedTree(t, 6, "sha256")
+	valid, err := ProveLeaf(ft, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, right := ft.Tree.Children[0], ft.Tree.Children[1]
+
+	tamper := func(f func(p *InclusionProof)) InclusionProof {
+		p := valid
+		p.Siblings = append([]string(nil), valid.Siblings...)
+		f(&p)
+		return p
+	}
+
+	cases := map[string]InclusionProof{
+		// The root itself claimed as the only leaf of a one-leaf tree
+		"root as leaf": {HashAlg: "sha256", LeafIndex: 0, Leaf: hex.EncodeToString(ft.Root)},
+		// An inner node claimed as a leaf of a two-leaf tree
+		"inner node as leaf": {
+			HashAlg:   "sha256",
+			LeafIndex: 0,
+			Leaf:      hex.EncodeToString(left.Hash),
+			Siblings:  []string{hex.EncodeToString(right.Hash)},
+		},
+		"wrong leaf":     tamper(func(p *InclusionProof) { p.Leaf = ft.Leaves[3] }),
+		"wrong index":    tamper(func(p *InclusionProof) { p.LeafIndex = 3 }),
+		"index too high": tamper(func(p *InclusionProof) { p.LeafIndex = 6 }),
+		"negative index": tamper(func(p *InclusionProof) { p.LeafIndex = -1 }),
+		"bad sibling":    tamper(func(p *InclusionProof) { p.Siblings[1] = ft.Leaves[0] }),
+		"too short":      tamper(func(p *InclusionProof) { p.Siblings = p.Siblings[:len(p.Siblings)-1] }),
+		"too long":       tamper(func(p *InclusionProof) { p.Siblings = append(p.Siblings, ft.Leaves[0]) }),
+		"other hash":     tamper(func(p *InclusionProof) { p.HashAlg = "blake2b" }),
+		"not hex":        tamper(func(p *InclusionProof) { p.Leaf = "zz" }),
+	}
+	for name, proof := range cases {
+		if err := VerifyInclusion(ft, proof); err == nil {
+			t.Errorf("%s: forged proof verified", name)
+		}
+	}
+}
+
+func TestVerifyInclusionRejectsXXH64(t *testing.T) {
+	ft := chunkedTree(t, 4, "xxh64")
+	if _, err := ProveLeaf(ft, 0); err == nil {
+		t.Error("ProveLeaf accepted an xxh64 tree")
+	}
+
+	// Build the proof by hand; the verifier must still refuse it
+	proof := InclusionProof{
+		HashAlg:   "xxh64",
+		LeafIndex: 0,
+		Leaf:      ft.Leaves[0],
+		Siblings: []string{
+			ft.Leaves[1],
+			hex.EncodeToString(ft.Tree.Children[1].Hash),
+		},
+	}
+	if err := VerifyInclusion(ft, proof); err == nil {
+		t.Error("VerifyInclusion accepted an xxh64 proof")
+	}
+}
+
+func TestLeafAndInnerHashesAreSeparated(t *testing.T) {
+	for _, name := range HashAlgorithmNames() {
+		hash, _ := LookupHashAlgorithm(name)
+		a, b := hash.Leaf([]byte("a")), hash.Leaf([]byte("b"))
+		concat := append(append([]byte{}, a...), b...)
+		if hex.EncodeToString(hash.Leaf(concat)) == hex.EncodeToString(hash.Combine.Combine(a, b)) {
+			t.Errorf("%s: a chunk holding two leaf hashes hashes like their parent", name)
+		}
+	}
+}
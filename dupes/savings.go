@@ -0,0 +1,104 @@
+package dupes
+
+import "fmt"
+
+// ============================================================================
+// SAVINGS
+// ============================================================================
+//
+// What each kind of group could give back. Exact duplicates can all go but
+// one copy. Similar files cannot be deleted outright, but a chunk-level
+// deduplicating store keeps each distinct chunk once. Visually similar media
+// differ byte for byte, so whether deleting them counts as saving space is
+// left to VisualSavings.
+
+// VisualSavings is how visual groups count towards savings.
+type VisualSavings string
+
+const (
+	VisualKeepLargest  VisualSavings = "keep-largest"  // keep the largest file, usually the best quality
+	VisualKeepSmallest VisualSavings = "keep-smallest" // keep the smallest file
+	VisualNone         VisualSavings = "none"          // visual matches save nothing
+)
+
+// ParseVisualSavings checks a policy name; "" is keep-largest.
+func ParseVisualSavings(name string) (VisualSavings, error) {
+	switch p := VisualSavings(name); p {
+	case "":
+		return VisualKeepLargest, nil
+	case VisualKeepLargest, VisualKeepSmallest, VisualNone:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown visual savings policy: %s (available: %s, %s, %s)",
+			name, VisualKeepLargest, VisualKeepSmallest, VisualNone)
+	}
+}
+
+// SavingsBreakdown is the space each group type could reclaim. A file's
+// bytes count towards at most one type, exact first, then similar, then
+// visual, so the three add up to what could actually be freed.
+type SavingsBreakdown struct {
+	Exact   int64
+	Similar int64
+	Visual  int64
+}
+
+// savingsByType sums the savings of groups by type.
+func savingsByType(groups []DuplicateGroup) SavingsBreakdown {
+	return FoldLeft(groups, SavingsBreakdown{}, func(acc SavingsBreakdown, g DuplicateGroup) SavingsBreakdown {
+		switch g.GroupType {
+		case "exact":
+			acc.Exact += g.Savings
+		case "similar":
+			acc.Similar += g.Savings
+		case "visual":
+			acc.Visual += g.Savings
+		}
+		return acc
+	})
+}
+
+// chunkSavings is what storing members' distinct chunks once would save:
+// their total size minus the bytes of distinct chunks. Exact copies count
+// once, since their exact group already reclaims the rest. Files without
+// chunk sizes are counted as all unique.
+func chunkSavings(members []FileTree) int64 {
+	seen := make(map[string]bool)
+	roots := make(map[string]bool)
+	var saved int64
+	for _, ft := range members {
+		if roots[RootKey(ft)] || len(ft.ChunkSizes) != len(ft.Leaves) {
+			continue
+		}
+		roots[RootKey(ft)] = true
+		for i, leaf := range ft.Leaves {
+			if seen[leaf] {
+				saved += int64(ft.ChunkSizes[i])
+			}
+			seen[leaf] = true
+		}
+	}
+	return saved
+}
+
+// visualSavings is what deleting all but one of members saves under policy.
+// Files in claimed are already counted by an exact or similar group, so they
+// add nothing here, though one may still be the file kept.
+func visualSavings(members []FileTree, claimed map[string]bool, policy VisualSavings) int64 {
+	if len(members) == 0 || policy == VisualNone {
+		return 0
+	}
+	keep := members[0]
+	for _, ft := range members[1:] {
+		if policy == VisualKeepSmallest && ft.Size < keep.Size ||
+			policy != VisualKeepSmallest && ft.Size > keep.Size {
+			keep = ft
+		}
+	}
+	return FoldLeft(members, int64(0), func(acc int64, ft FileTree) int64 {
+		if ft.Path == keep.Path || claimed[ft.Path] {
+			return acc
+		}
+		return acc + ft.Size
+	})
+}
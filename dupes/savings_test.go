@@ -0,0 +1,40 @@
+package dupes
+
+import "testing"
+
+func TestVisualSavingsSkipsClaimedFiles(t *testing.T) {
+	members := []FileTree{
+		{Path: "a.jpg", Size: 300},
+		{Path: "b.jpg", Size: 200},
+		{Path: "c.jpg", Size: 100},
+	}
+	cases := []struct {
+		policy  VisualSavings
+		claimed map[string]bool
+		want    int64
+	}{
+		{VisualKeepLargest, nil, 300},
+		{VisualKeepSmallest, nil, 500},
+		{VisualNone, nil, 0},
+		// b is counted by its similar group already
+		{VisualKeepLargest, map[string]bool{"b.jpg": true}, 100},
+		// the kept file being claimed elsewhere changes nothing
+		{VisualKeepLargest, map[string]bool{"a.jpg": true}, 300},
+	}
+	for _, c := range cases {
+		if got := visualSavings(members, c.claimed, c.policy); got != c.want {
+			t.Errorf("%s with %v claimed: %d, want %d", c.policy, c.claimed, got, c.want)
+		}
+	}
+}
+
+func TestChunkSavingsCountsExactCopiesOnce(t *testing.T) {
+	_, a := chunkFile(t, "a", 1, 2, 3, 4)
+	_, copyOfA := chunkFile(t, "copy", 1, 2, 3, 4)
+	_, b := chunkFile(t, "b", 1, 2, 3, 9)
+
+	// b repeats three of a's 16-byte chunks; the copy is the exact group's
+	if got := chunkSavings([]FileTree{a, copyOfA, b}); got != 48 {
+		t.Errorf("chunkSavings = %d, want 48", got)
+	}
+}
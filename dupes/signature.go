@@ -0,0 +1,480 @@
+package dupes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ============================================================================
+// SIGNATURES
+// ============================================================================
+
+// SignatureVersion is the current signature format. Decoders accept every
+// version up to this one and reject anything newer.
+const SignatureVersion = 1
+
+// signatureMagic starts every binary signature.
+var signatureMagic = []byte("PDSG")
+
+// ErrIncompatibleSignature is wrapped by compatibility checks when two
+// signatures were built with different hash algorithms or chunkers.
+var ErrIncompatibleSignature = errors.New("incompatible signature")
+
+// Signature is the portable form of a FileTree: everything needed to match a
+// file again without its content. The Merkle tree is not stored; FileTree
+// rebuilds it from the leaves. Signatures encode to a compact binary form
+// (MarshalBinary) or to JSON with hex hashes (MarshalJSON).
+type Signature struct {
+	Path    string
+	Size    int64
+	ModTime int64
+	Stage   string
+
+	HashAlg string
+	Chunker ChunkerSpec
+
+	Root       []byte
+	Leaves     [][]byte
+	ChunkSizes []int
+	LowEntropy []int
+
+	PHash     uint64
+	IsImage   bool
+	VideoHash []uint64
+	IsVideo   bool
+}
+
+// NewSignature captures ft, which was chunked by chunker.
+func NewSignature(ft FileTree, chunker ChunkerSpec) (Signature, error) {
+	leaves := make([][]byte, len(ft.Leaves))
+	for i, leaf := range ft.Leaves {
+		b, err := hex.DecodeString(leaf)
+		if err != nil {
+			return Signature{}, fmt.Errorf("%s: leaf %d: %v", ft.Path, i, err)
+		}
+		leaves[i] = b
+	}
+
+	return Signature{
+		Path:       ft.Path,
+		Size:       ft.Size,
+		ModTime:    ft.ModTime,
+		Stage:      ft.Stage,
+		HashAlg:    ft.HashAlg,
+		Chunker:    chunker,
+		Root:       ft.Root,
+		Leaves:     leaves,
+		ChunkSizes: ft.ChunkSizes,
+		LowEntropy: ft.LowEntropy,
+		PHash:      ft.PHash,
+		IsImage:    ft.IsImage,
+		VideoHash:  ft.VideoHash,
+		IsVideo:    ft.IsVideo,
+	}, nil
+}
+
+// FileTree turns the signature back into a FileTree, rebuilding the Merkle
+// tree and checking it against the stored Root.
+func (s Signature) FileTree() (FileTree, error) {
+	hash, err := LookupHashAlgorithm(s.HashAlg)
+	if err != nil {
+		return FileTree{}, err
+	}
+
+	ft := FileTree{
+		Path:       s.Path,
+		Root:       s.Root,
+		Size:       s.Size,
+		ChunkCount: len(s.Leaves),
+		Leaves:     Map(s.Leaves, hex.EncodeToString),
+		ChunkSizes: s.ChunkSizes,
+		LowEntropy: s.LowEntropy,
+		ModTime:    s.ModTime,
+		HashAlg:    s.HashAlg,
+		Chunker:    s.Chunker,
+		Stage:      s.Stage,
+		PHash:      s.PHash,
+		IsImage:    s.IsImage,
+		VideoHash:  s.VideoHash,
+		IsVideo:    s.IsVideo,
+	}
+
+	if s.Stage == StageFull && len(s.Leaves) > 0 {
+		ft.Tree = BuildMerkleTree(s.Leaves, hash.Combine)
+		if !bytes.Equal(ft.Tree.Hash, s.Root) {
+			return FileTree{}, fmt.Errorf("%s: leaves do not match the signature root", s.Path)
+		}
+	}
+	return ft, nil
+}
+
+// Matches reports whether the signature was built with the given chunker and
+// hash algorithm. chunker should come from Chunker.Spec so defaults are
+// filled in the same way on both sides.
+func (s Signature) Matches(chunker ChunkerSpec, hashName string) error {
+	if hashName == "" {
+		hashName = "sha256"
+	}
+	if s.HashAlg != hashName {
+		return fmt.Errorf("%w: %s was hashed with %q, not %q", ErrIncompatibleSignature, s.Path, s.HashAlg, hashName)
+	}
+	if s.Chunker != chunker {
+		return fmt.Errorf("%w: %s was chunked with %+v, not %+v", ErrIncompatibleSignature, s.Path, s.Chunker, chunker)
+	}
+	return nil
+}
+
+// CompatibleWith reports whether roots and leaves of s and o can be compared.
+func (s Signature) CompatibleWith(o Signature) error {
+	return s.Matches(o.Chunker, o.HashAlg)
+}
+
+// ============================================================================
+// BINARY ENCODING
+// ============================================================================
+
+const (
+	sigFlagImage = 1 << iota
+	sigFlagVideo
+)
+
+// MarshalBinary encodes the signature as "PDSG", a uvarint version, then the
+// fields in declaration order. Integers are varints, strings and byte slices
+// are uvarint length-prefixed, and leaves share one length since a hash
+// algorithm always produces digests of the same size. LowEntropy holds
+// ascending leaf indices, stored as gaps.
+func (s Signature) MarshalBinary() ([]byte, error) {
+	digestLen := 0
+	if len(s.Leaves) > 0 {
+		digestLen = len(s.Leaves[0])
+	}
+	for i, leaf := range s.Leaves {
+		if len(leaf) != digestLen {
+			return nil, fmt.Errorf("%s: leaf %d is %d bytes, expected %d", s.Path, i, len(leaf), digestLen)
+		}
+	}
+	for i, leaf := range s.LowEntropy {
+		if leaf < 0 || (i > 0 && leaf <= s.LowEntropy[i-1]) {
+			return nil, fmt.Errorf("%s: low-entropy leaf indices must be ascending", s.Path)
+		}
+	}
+
+	buf := make([]byte, 0, 64+len(s.Path)+len(s.Root)+len(s.Leaves)*(digestLen+3))
+	buf = append(buf, signatureMagic...)
+	buf = binary.AppendUvarint(buf, SignatureVersion)
+
+	buf = appendString(buf, s.Path)
+	buf = binary.AppendVarint(buf, s.Size)
+	buf = binary.AppendVarint(buf, s.ModTime)
+	buf = appendString(buf, s.Stage)
+
+	buf = appendString(buf, s.HashAlg)
+	buf = appendString(buf, s.Chunker.Name)
+	buf = binary.AppendVarint(buf, int64(s.Chunker.Size))
+	buf = binary.AppendVarint(buf, int64(s.Chunker.MinSize))
+	buf = binary.AppendVarint(buf, int64(s.Chunker.MaxSize))
+
+	buf = appendBytes(buf, s.Root)
+	buf = binary.AppendUvarint(buf, uint64(len(s.Leaves)))
+	buf = binary.AppendUvarint(buf, uint64(digestLen))
+	for _, leaf := range s.Leaves {
+		buf = append(buf, leaf...)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(s.ChunkSizes)))
+	for _, size := range s.ChunkSizes {
+		buf = binary.AppendUvarint(buf, uint64(size))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(s.LowEntropy)))
+	prev := 0
+	for _, i := range s.LowEntropy {
+		buf = binary.AppendUvarint(buf, uint64(i-prev))
+		prev = i
+	}
+
+	var flags byte
+	if s.IsImage {
+		flags |= sigFlagImage
+	}
+	if s.IsVideo {
+		flags |= sigFlagVideo
+	}
+	buf = append(buf, flags)
+	buf = binary.BigEndian.AppendUint64(buf, s.PHash)
+	buf = binary.AppendUvarint(buf, uint64(len(s.VideoHash)))
+	for _, h := range s.VideoHash {
+		buf = binary.BigEndian.AppendUint64(buf, h)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a signature written by MarshalBinary.
+func (s *Signature) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, signatureMagic) {
+		return errors.New("not a pure-dupes signature")
+	}
+	r := sigReader{data: data[len(signatureMagic):]}
+
+	version := r.uvarint()
+	if r.err == nil && (version == 0 || version > SignatureVersion) {
+		return fmt.Errorf("unsupported signature version %d (this build reads up to %d)", version, SignatureVersion)
+	}
+
+	var sig Signature
+	sig.Path = r.string()
+	sig.Size = r.varint()
+	sig.ModTime = r.varint()
+	sig.Stage = r.string()
+
+	sig.HashAlg = r.string()
+	sig.Chunker.Name = r.string()
+	sig.Chunker.Size = int(r.varint())
+	sig.Chunker.MinSize = int(r.varint())
+	sig.Chunker.MaxSize = int(r.varint())
+
+	sig.Root = r.bytes()
+	leafCount := r.count(1)
+	digestLen := int(r.uvarint())
+	if leafCount > 0 {
+		sig.Leaves = make([][]byte, leafCount)
+		for i := range sig.Leaves {
+			sig.Leaves[i] = r.next(digestLen)
+		}
+	}
+	if n := r.count(1); n > 0 {
+		sig.ChunkSizes = make([]int, n)
+		for i := range sig.ChunkSizes {
+			sig.ChunkSizes[i] = int(r.uvarint())
+		}
+	}
+	if n := r.count(1); n > 0 {
+		sig.LowEntropy = make([]int, n)
+		prev := 0
+		for i := range sig.LowEntropy {
+			prev += int(r.uvarint())
+			sig.LowEntropy[i] = prev
+		}
+	}
+
+	flags := r.next(1)
+	if len(flags) == 1 {
+		sig.IsImage = flags[0]&sigFlagImage != 0
+		sig.IsVideo = flags[0]&sigFlagVideo != 0
+	}
+	sig.PHash = r.uint64()
+	if n := r.count(8); n > 0 {
+		sig.VideoHash = make([]uint64, n)
+		for i := range sig.VideoHash {
+			sig.VideoHash[i] = r.uint64()
+		}
+	}
+
+	if r.err != nil {
+		return fmt.Errorf("corrupt signature: %v", r.err)
+	}
+	*s = sig
+	return nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// sigReader decodes signature fields, remembering the first error so callers
+// can check once at the end.
+type sigReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sigReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.data = nil
+}
+
+func (r *sigReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.fail(errors.New("unexpected end of data"))
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *sigReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail(errors.New("bad uvarint"))
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *sigReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail(errors.New("bad varint"))
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count reads an element count, rejecting counts that could not fit in the
+// remaining data at minSize bytes per element.
+func (r *sigReader) count(minSize int) int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)/minSize) {
+		r.fail(fmt.Errorf("count %d exceeds remaining data", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (r *sigReader) bytes() []byte {
+	return r.next(r.count(1))
+}
+
+func (r *sigReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *sigReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// ============================================================================
+// JSON ENCODING
+// ============================================================================
+
+// jsonSignature is the JSON form. Hashes are hex strings, and 64-bit media
+// hashes are strings too since JavaScript numbers cannot hold them.
+type jsonSignature struct {
+	Version    int             `json:"version"`
+	Path       string          `json:"path"`
+	Size       int64           `json:"size"`
+	ModTime    int64           `json:"modTime,omitempty"`
+	Stage      string          `json:"stage"`
+	HashAlg    string          `json:"hash"`
+	Chunker    jsonChunkerSpec `json:"chunker"`
+	Root       string          `json:"root,omitempty"`
+	Leaves     []string        `json:"leaves,omitempty"`
+	ChunkSizes []int           `json:"chunkSizes,omitempty"`
+	LowEntropy []int           `json:"lowEntropy,omitempty"`
+	PHash      string          `json:"pHash,omitempty"`
+	IsImage    bool            `json:"isImage,omitempty"`
+	VideoHash  []string        `json:"videoHash,omitempty"`
+	IsVideo    bool            `json:"isVideo,omitempty"`
+}
+
+type jsonChunkerSpec struct {
+	Name    string `json:"name"`
+	Size    int    `json:"size,omitempty"`
+	MinSize int    `json:"minSize,omitempty"`
+	MaxSize int    `json:"maxSize,omitempty"`
+}
+
+func (s Signature) MarshalJSON() ([]byte, error) {
+	js := jsonSignature{
+		Version:    SignatureVersion,
+		Path:       s.Path,
+		Size:       s.Size,
+		ModTime:    s.ModTime,
+		Stage:      s.Stage,
+		HashAlg:    s.HashAlg,
+		Chunker:    jsonChunkerSpec(s.Chunker),
+		Root:       hex.EncodeToString(s.Root),
+		Leaves:     Map(s.Leaves, hex.EncodeToString),
+		ChunkSizes: s.ChunkSizes,
+		LowEntropy: s.LowEntropy,
+		IsImage:    s.IsImage,
+		VideoHash:  Map(s.VideoHash, formatHash64),
+		IsVideo:    s.IsVideo,
+	}
+	if s.PHash != 0 {
+		js.PHash = formatHash64(s.PHash)
+	}
+	return json.Marshal(js)
+}
+
+func (s *Signature) UnmarshalJSON(data []byte) error {
+	var js jsonSignature
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	if js.Version == 0 || js.Version > SignatureVersion {
+		return fmt.Errorf("unsupported signature version %d (this build reads up to %d)", js.Version, SignatureVersion)
+	}
+
+	sig := Signature{
+		Path:       js.Path,
+		Size:       js.Size,
+		ModTime:    js.ModTime,
+		Stage:      js.Stage,
+		HashAlg:    js.HashAlg,
+		Chunker:    ChunkerSpec(js.Chunker),
+		ChunkSizes: js.ChunkSizes,
+		LowEntropy: js.LowEntropy,
+		IsImage:    js.IsImage,
+		IsVideo:    js.IsVideo,
+	}
+
+	var err error
+	if sig.Root, err = hex.DecodeString(js.Root); err != nil {
+		return fmt.Errorf("root: %v", err)
+	}
+	for i, leaf := range js.Leaves {
+		b, err := hex.DecodeString(leaf)
+		if err != nil {
+			return fmt.Errorf("leaf %d: %v", i, err)
+		}
+		sig.Leaves = append(sig.Leaves, b)
+	}
+	if js.PHash != "" {
+		if sig.PHash, err = strconv.ParseUint(js.PHash, 16, 64); err != nil {
+			return fmt.Errorf("pHash: %v", err)
+		}
+	}
+	for i, h := range js.VideoHash {
+		v, err := strconv.ParseUint(h, 16, 64)
+		if err != nil {
+			return fmt.Errorf("videoHash %d: %v", i, err)
+		}
+		sig.VideoHash = append(sig.VideoHash, v)
+	}
+
+	*s = sig
+	return nil
+}
+
+func formatHash64(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
@@ -0,0 +1,143 @@
+package dupes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chunkFile builds a FileTree whose 16-byte chunks are the numbered chunks
+// in ids.
+func chunkFile(t *testing.T, path string, ids ...int) (JSFile, FileTree) {
+	t.Helper()
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "chunk %09d\n", id)
+	}
+	file := JSFile{Path: path, Size: int64(b.Len()), Data: []byte(b.String())}
+	ft, err := ProcessFile(file, FixedChunker{Size: 16}, DefaultOptions().Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, ft
+}
+
+// testSignature is a signature with every field set.
+func testSignature(t *testing.T) Signature {
+	t.Helper()
+	_, ft := chunkFile(t, "/sig/file", 1, 2, 3, 2, 4)
+	ft.ModTime = 1700000000
+	ft.LowEntropy = []int{1, 3}
+	ft.PHash = 0xfedcba9876543210
+	ft.IsImage = true
+	ft.VideoHash = []uint64{1, 1 << 63}
+	ft.IsVideo = true
+	sig, err := NewSignature(ft, FixedChunker{Size: 16}.Spec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	sig := testSignature(t)
+
+	data, err := sig.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromBinary Signature
+	if err := fromBinary.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromBinary, sig) {
+		t.Errorf("binary round trip:\ngot  %+v\nwant %+v", fromBinary, sig)
+	}
+
+	data, err = json.Marshal(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON Signature
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, sig) {
+		t.Errorf("JSON round trip:\ngot  %+v\nwant %+v", fromJSON, sig)
+	}
+
+	ft, err := fromBinary.FileTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ft.Tree.Hash, sig.Root) || len(ft.Leaves) != 5 || !reflect.DeepEqual(ft.LowEntropy, []int{1, 3}) {
+		t.Errorf("FileTree() = %+v", ft)
+	}
+}
+
+func TestSignatureRejectsCorruptBinary(t *testing.T) {
+	data, err := testSignature(t).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 0; n < len(data); n++ {
+		var sig Signature
+		if err := sig.UnmarshalBinary(data[:n]); err == nil {
+			t.Fatalf("truncated to %d of %d bytes: no error", n, len(data))
+		}
+	}
+
+	badMagic := append([]byte("XXSG"), data[4:]...)
+	if err := new(Signature).UnmarshalBinary(badMagic); err == nil {
+		t.Error("bad magic accepted")
+	}
+
+	// version is the byte after the magic
+	for _, version := range []byte{0, SignatureVersion + 1} {
+		newer := append([]byte{}, data...)
+		newer[len(signatureMagic)] = version
+		err := new(Signature).UnmarshalBinary(newer)
+		if err == nil || !strings.Contains(err.Error(), "unsupported signature version") {
+			t.Errorf("version %d: %v, want unsupported version", version, err)
+		}
+	}
+}
+
+func TestSignatureRejectsTamperedLeaves(t *testing.T) {
+	sig := testSignature(t)
+	sig.Leaves[2] = append([]byte{}, sig.Leaves[2]...)
+	sig.Leaves[2][0] ^= 1
+	if _, err := sig.FileTree(); err == nil {
+		t.Error("FileTree accepted leaves that do not hash to the root")
+	}
+}
+
+func TestSignatureJSONRejectsUnknownVersion(t *testing.T) {
+	for _, doc := range []string{`{"path":"a"}`, `{"version":99,"path":"a"}`} {
+		if err := json.Unmarshal([]byte(doc), new(Signature)); err == nil {
+			t.Errorf("%s accepted", doc)
+		}
+	}
+}
+
+func TestSignatureMatches(t *testing.T) {
+	sig := testSignature(t)
+	if err := sig.Matches(FixedChunker{Size: 16}.Spec(), ""); err != nil {
+		t.Errorf("same settings: %v", err)
+	}
+	for _, c := range []struct {
+		chunker ChunkerSpec
+		hash    string
+	}{
+		{FixedChunker{Size: 32}.Spec(), "sha256"},
+		{FixedChunker{Size: 16}.Spec(), "blake2b"},
+	} {
+		if err := sig.Matches(c.chunker, c.hash); !errors.Is(err, ErrIncompatibleSignature) {
+			t.Errorf("Matches(%+v, %s) = %v, want ErrIncompatibleSignature", c.chunker, c.hash, err)
+		}
+	}
+}
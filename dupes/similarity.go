@@ -0,0 +1,199 @@
+package dupes
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ============================================================================
+// SIMILARITY METRICS
+// ============================================================================
+
+// SimilarityScores compares the chunk sequences of two files a and b in
+// several ways. Overlap is CompareFiles; it ignores order and repeats, so a
+// file of zero blocks overlaps fully with anything holding one zero block.
+// The other scores count every repeat of a chunk and are 0 for such pairs.
+type SimilarityScores struct {
+	Overlap    float64 // share of a's chunks found anywhere in b
+	Jaccard    float64 // shared chunks over all chunks of both, as multisets
+	AInB       float64 // share of a's chunks, repeats included, that b also holds
+	BInA       float64 // share of b's chunks, repeats included, that a also holds
+	LongestRun float64 // longest run of consecutive chunks in both, over the longer file's chunk count
+}
+
+// ScoreFiles computes every SimilarityScores metric for a against b. Files
+// with the same root score 1 throughout.
+func ScoreFiles(a, b FileTree) SimilarityScores {
+	if a.HashAlg != b.HashAlg {
+		return SimilarityScores{}
+	}
+	if RootKey(a) == RootKey(b) {
+		return SimilarityScores{Overlap: 1, Jaccard: 1, AInB: 1, BInA: 1, LongestRun: 1}
+	}
+	if len(a.Leaves) == 0 || len(b.Leaves) == 0 {
+		return SimilarityScores{}
+	}
+
+	// Each chunk of a uses up one copy of it in b
+	remaining := FoldLeft(b.Leaves, make(map[string]int, len(b.Leaves)),
+		func(acc map[string]int, leaf string) map[string]int {
+			acc[leaf]++
+			return acc
+		})
+	shared := FoldLeft(a.Leaves, 0, func(acc int, leaf string) int {
+		if remaining[leaf] > 0 {
+			remaining[leaf]--
+			return acc + 1
+		}
+		return acc
+	})
+
+	nA, nB := len(a.Leaves), len(b.Leaves)
+	return SimilarityScores{
+		Overlap:    CompareFiles(a, b),
+		Jaccard:    float64(shared) / float64(nA+nB-shared),
+		AInB:       float64(shared) / float64(nA),
+		BInA:       float64(shared) / float64(nB),
+		LongestRun: float64(longestCommonRun(a.Leaves, b.Leaves)) / float64(max(nA, nB)),
+	}
+}
+
+// longestCommonRun is the length of the longest run of consecutive chunks
+// that occurs in both a and b. It builds a suffix automaton of b and walks a
+// through it, so it is linear in the chunk counts even for files made of a
+// few chunks repeated many times.
+func longestCommonRun(a, b []string) int {
+	type state struct {
+		length int
+		link   int
+		next   map[string]int
+	}
+
+	states := []state{{link: -1, next: map[string]int{}}}
+	last := 0
+	for _, c := range b {
+		cur := len(states)
+		states = append(states, state{length: states[last].length + 1, next: map[string]int{}})
+
+		p := last
+		for ; p != -1; p = states[p].link {
+			if _, ok := states[p].next[c]; ok {
+				break
+			}
+			states[p].next[c] = cur
+		}
+
+		if p == -1 {
+			states[cur].link = 0
+		} else if q := states[p].next[c]; states[p].length+1 == states[q].length {
+			states[cur].link = q
+		} else {
+			clone := len(states)
+			next := make(map[string]int, len(states[q].next))
+			for k, v := range states[q].next {
+				next[k] = v
+			}
+			states = append(states, state{length: states[p].length + 1, link: states[q].link, next: next})
+			for ; p != -1 && states[p].next[c] == q; p = states[p].link {
+				states[p].next[c] = clone
+			}
+			states[q].link = clone
+			states[cur].link = clone
+		}
+		last = cur
+	}
+
+	best, v, length := 0, 0, 0
+	for _, c := range a {
+		for v != 0 {
+			if _, ok := states[v].next[c]; ok {
+				break
+			}
+			v = states[v].link
+			length = states[v].length
+		}
+		if next, ok := states[v].next[c]; ok {
+			v, length = next, length+1
+		} else {
+			v, length = 0, 0
+		}
+		best = max(best, length)
+	}
+	return best
+}
+
+// SimilarityMetric picks the score partial matches are thresholded and
+// reported on. The zero value is overlap, the CompareFiles score.
+type SimilarityMetric struct {
+	Name  string
+	Score func(SimilarityScores) float64
+
+	// TwoSided metrics can score high on b's share of its own chunks, which
+	// overlap from a's side does not bound, so candidates cannot be pruned.
+	TwoSided bool
+}
+
+// CandidateThreshold is the FindCandidates threshold that keeps every file
+// able to reach threshold under m. One-sided metrics never score above
+// overlap, so threshold itself will do; two-sided ones need any shared chunk.
+func (m SimilarityMetric) CandidateThreshold(threshold float64) float64 {
+	if m.TwoSided {
+		return 0
+	}
+	return threshold
+}
+
+// Of applies m to scores.
+func (m SimilarityMetric) Of(scores SimilarityScores) float64 {
+	if m.Score == nil {
+		return scores.Overlap
+	}
+	return m.Score(scores)
+}
+
+var similarityMetrics = map[string]SimilarityMetric{}
+
+func RegisterSimilarityMetric(metric SimilarityMetric) {
+	similarityMetrics[metric.Name] = metric
+}
+
+func LookupSimilarityMetric(name string) (SimilarityMetric, error) {
+	if name == "" {
+		name = "overlap"
+	}
+	metric, ok := similarityMetrics[name]
+	if !ok {
+		return SimilarityMetric{}, fmt.Errorf("unknown similarity metric: %s (available: %v)", name, SimilarityMetricNames())
+	}
+	return metric, nil
+}
+
+func SimilarityMetricNames() []string {
+	names := make([]string, 0, len(similarityMetrics))
+	for name := range similarityMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterSimilarityMetric(SimilarityMetric{
+		Name:  "overlap",
+		Score: func(s SimilarityScores) float64 { return s.Overlap },
+	})
+	RegisterSimilarityMetric(SimilarityMetric{
+		Name:  "jaccard",
+		Score: func(s SimilarityScores) float64 { return s.Jaccard },
+	})
+	// Either file inside the other, so argument order does not matter
+	RegisterSimilarityMetric(SimilarityMetric{
+		Name:     "containment",
+		Score:    func(s SimilarityScores) float64 { return max(s.AInB, s.BInA) },
+		TwoSided: true,
+	})
+	RegisterSimilarityMetric(SimilarityMetric{
+		Name:  "run",
+		Score: func(s SimilarityScores) float64 { return s.LongestRun },
+	})
+}
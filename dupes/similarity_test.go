@@ -0,0 +1,78 @@
+package dupes
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func TestContainmentIgnoresArgumentOrder(t *testing.T) {
+	_, small := chunkFile(t, "small", 1, 2, 3)
+	_, large := chunkFile(t, "large", 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	containment, err := LookupSimilarityMetric("containment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pair := range [][2]FileTree{{small, large}, {large, small}} {
+		if got := containment.Of(ScoreFiles(pair[0], pair[1])); got != 1 {
+			t.Errorf("containment(%s, %s) = %v, want 1", pair[0].Path, pair[1].Path, got)
+		}
+	}
+
+	// large shares 3 of its 10 chunks: below threshold, but still a candidate
+	index := BuildChunkIndex([]FileTree{small})
+	if _, ok := FindCandidates(large, index, containment.CandidateThreshold(0.8))[0]; !ok {
+		t.Error("small file was pruned as a containment candidate of the large one")
+	}
+}
+
+func TestPartialMatchScoringOneIsKept(t *testing.T) {
+	// Same chunks, one repeated: overlap 1 both ways, different roots
+	a, _ := chunkFile(t, "/s/a", 1, 2, 3, 4, 5)
+	b, _ := chunkFile(t, "/s/b", 1, 2, 3, 4, 5, 1)
+
+	opts := DefaultOptions()
+	opts.Chunker = FixedChunker{Size: 16}
+	result, _, err := AnalyzeContext(context.Background(), []JSFile{a, b}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.DuplicateGroups) != 1 || result.DuplicateGroups[0].GroupType != "similar" {
+		t.Fatalf("groups = %+v, want one similar group", result.DuplicateGroups)
+	}
+	if got := result.DuplicateGroups[0].Similarity; got != 1 {
+		t.Errorf("similarity = %v, want 1", got)
+	}
+}
+
+func TestLongestCommonRun(t *testing.T) {
+	brute := func(a, b []string) int {
+		best := 0
+		for i := range a {
+			for j := range b {
+				n := 0
+				for i+n < len(a) && j+n < len(b) && a[i+n] == b[j+n] {
+					n++
+				}
+				best = max(best, n)
+			}
+		}
+		return best
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	random := func() []string {
+		s := make([]string, rng.Intn(30))
+		for i := range s {
+			s[i] = string(rune('a' + rng.Intn(3)))
+		}
+		return s
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		if got, want := longestCommonRun(a, b), brute(a, b); got != want {
+			t.Fatalf("longestCommonRun(%v, %v) = %d, want %d", a, b, got, want)
+		}
+	}
+}
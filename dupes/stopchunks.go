@@ -0,0 +1,121 @@
+package dupes
+
+import (
+	"math"
+	"sort"
+)
+
+// ============================================================================
+// STOP CHUNKS
+// ============================================================================
+//
+// Zero-filled blocks, padding and common headers turn up in unrelated files.
+// Like stop words in text search, partial matching leaves them out of the
+// chunk index and of similarity scores, so they neither make two files
+// candidates nor raise their score.
+
+// LowEntropyBits is the Shannon entropy, in bits per byte, below which
+// ProcessFile flags a chunk as low entropy. A run of one repeated byte has
+// entropy 0; text is around 4 and compressed data close to 8.
+const LowEntropyBits = 1.0
+
+// StopChunkOptions decides which chunks partial matching ignores.
+type StopChunkOptions struct {
+	MaxFiles   int  // chunks found in more than this many files are ignored; 0 keeps them
+	LowEntropy bool // chunks ProcessFile flagged as low entropy are ignored
+}
+
+// StopChunk is a chunk partial matching ignored.
+type StopChunk struct {
+	Hash   string
+	Files  int    // how many files contain it
+	Reason string // "low-entropy" or "common"
+}
+
+// chunkEntropy is the Shannon entropy of chunk in bits per byte.
+func chunkEntropy(chunk []byte) float64 {
+	if len(chunk) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range chunk {
+		counts[b]++
+	}
+	n := float64(len(chunk))
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// findStopChunks lists the chunks of chunkIndex that opts ignores, most
+// widespread first.
+func findStopChunks(fileTrees []FileTree, chunkIndex map[string][]int, opts StopChunkOptions) []StopChunk {
+	lowEntropy := make(map[string]bool)
+	if opts.LowEntropy {
+		for _, ft := range fileTrees {
+			for _, i := range ft.LowEntropy {
+				if i >= 0 && i < len(ft.Leaves) {
+					lowEntropy[ft.Leaves[i]] = true
+				}
+			}
+		}
+	}
+
+	stops := []StopChunk{}
+	for hash, files := range chunkIndex {
+		switch {
+		case lowEntropy[hash]:
+			stops = append(stops, StopChunk{Hash: hash, Files: len(files), Reason: "low-entropy"})
+		case opts.MaxFiles > 0 && len(files) > opts.MaxFiles:
+			stops = append(stops, StopChunk{Hash: hash, Files: len(files), Reason: "common"})
+		}
+	}
+
+	sort.Slice(stops, func(i, j int) bool {
+		if stops[i].Files != stops[j].Files {
+			return stops[i].Files > stops[j].Files
+		}
+		return stops[i].Hash < stops[j].Hash
+	})
+	return stops
+}
+
+// StopChunkSet returns the hashes of stops, for WithoutChunks.
+func StopChunkSet(stops []StopChunk) map[string]bool {
+	return FoldLeft(stops, make(map[string]bool, len(stops)), func(acc map[string]bool, s StopChunk) map[string]bool {
+		acc[s.Hash] = true
+		return acc
+	})
+}
+
+// WithoutChunks returns ft with the leaves in stop left out, for scoring.
+// Root and Tree are kept, so exact matching is unaffected, but the leaves
+// no longer line up with the Merkle tree or byte offsets.
+func WithoutChunks(ft FileTree, stop map[string]bool) FileTree {
+	if len(stop) == 0 {
+		return ft
+	}
+
+	keepSizes := len(ft.ChunkSizes) == len(ft.Leaves)
+	leaves := make([]string, 0, len(ft.Leaves))
+	var sizes []int
+	for i, leaf := range ft.Leaves {
+		if stop[leaf] {
+			continue
+		}
+		leaves = append(leaves, leaf)
+		if keepSizes {
+			sizes = append(sizes, ft.ChunkSizes[i])
+		}
+	}
+
+	ft.Leaves = leaves
+	ft.ChunkSizes = sizes
+	ft.LowEntropy = nil
+	return ft
+}
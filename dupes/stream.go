@@ -0,0 +1,132 @@
+package dupes
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// ============================================================================
+// STREAMING INGESTION
+// ============================================================================
+
+// maxChunkBuffer bounds the scanner buffer. Every chunker caps its chunk size
+// well below this; it only guards against a split function that never cuts.
+const maxChunkBuffer = 64 << 20
+
+// Reader opens the file's content: through Open when set, otherwise from
+// Data. Native walks and the WASM streaming mode set Open so content is read
+// a chunk at a time and never held whole.
+func (f JSFile) Reader() (io.ReadCloser, error) {
+	if f.Open != nil {
+		return f.Open()
+	}
+	return nopCloser{bytes.NewReader(f.Data)}, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// streamChunks feeds r through split and calls visit with each chunk. The
+// slice passed to visit is only valid until visit returns.
+func streamChunks(r io.Reader, split bufio.SplitFunc, visit func(chunk []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxChunkBuffer)
+	scanner.Split(split)
+
+	for scanner.Scan() {
+		visit(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+// readEnds returns the first and last n bytes of a size-byte stream. Readers
+// with random access (files, WASM blobs) are read at both ends; anything else
+// is streamed keeping only the last n bytes.
+func readEnds(r io.Reader, size int64, n int) ([]byte, []byte, error) {
+	if size <= int64(2*n) {
+		data, err := io.ReadAll(r)
+		return data, nil, err
+	}
+
+	head := make([]byte, n)
+	tail := make([]byte, n)
+
+	if ra, ok := r.(io.ReaderAt); ok {
+		if _, err := ra.ReadAt(head, 0); err != nil {
+			return nil, nil, err
+		}
+		if _, err := ra.ReadAt(tail, size-int64(n)); err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		return head, tail, nil
+	}
+
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, nil, err
+	}
+
+	// Ring buffer of the most recent n bytes
+	buf := make([]byte, 32*1024)
+	filled, pos := 0, 0
+	for {
+		m, err := r.Read(buf)
+		for _, b := range buf[:m] {
+			tail[pos] = b
+			pos = (pos + 1) % n
+		}
+		filled += m
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if filled < n {
+		return head, tail[:filled], nil
+	}
+	return head, append(tail[pos:], tail[:pos]...), nil
+}
+
+// teePHash computes an image's pHash from the bytes read through the
+// returned reader, so the image is decoded while it is being chunked
+// rather than read a second time. Call finish after reading to EOF.
+func teePHash(r io.Reader) (io.Reader, func(readErr error) uint64) {
+	pr, pw := io.Pipe()
+	result := make(chan uint64, 1)
+
+	go func() {
+		hash, err := computePHash(pr)
+		if err != nil {
+			hash = 0
+		}
+		// The decoder may stop early; drain so the writer never blocks
+		io.Copy(io.Discard, pr)
+		result <- hash
+	}()
+
+	finish := func(readErr error) uint64 {
+		pw.CloseWithError(readErr)
+		return <-result
+	}
+	return io.TeeReader(r, pw), finish
+}
+
+// readPHash opens a file just to hash it as an image.
+func readPHash(file JSFile) uint64 {
+	r, err := file.Reader()
+	if err != nil {
+		return 0
+	}
+	defer r.Close()
+
+	hash, err := computePHash(r)
+	if err != nil {
+		return 0
+	}
+	return hash
+}
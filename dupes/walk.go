@@ -0,0 +1,194 @@
+package dupes
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================================
+// DIRECTORY WALKING
+// ============================================================================
+
+// WalkOptions controls which files CollectFiles picks up from disk.
+type WalkOptions struct {
+	// Glob patterns (filepath.Match syntax). Patterns containing a slash are
+	// matched against the path relative to the root, others against the
+	// base name. An empty Include list accepts every file.
+	Include []string
+	Exclude []string
+
+	MaxDepth       int  // 0 is unlimited; 1 only looks at files directly in a root
+	FollowSymlinks bool // otherwise symlinks are skipped
+	IncludeHidden  bool // dot files and dot directories
+
+	// OnError is told about entries that could not be read; they are skipped.
+	OnError func(path string, err error)
+}
+
+// CollectFiles walks each root and records the matching files. Files are
+// returned in lexical order per root so results are reproducible. A file
+// reached from several roots, such as a directory and one inside it, is
+// listed once, under the first.
+func CollectFiles(roots []string, opts WalkOptions) ([]JSFile, error) {
+	return CollectFilesContext(context.Background(), roots, opts)
+}
+
+// CollectFilesContext is CollectFiles that gives up once ctx is cancelled.
+func CollectFilesContext(ctx context.Context, roots []string, opts WalkOptions) ([]JSFile, error) {
+	files := []JSFile{}
+	seen := make(map[string]bool) // absolute paths already listed
+
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+
+		w := walker{ctx: ctx, root: root, opts: opts, visited: make(map[string]bool)}
+		if !info.IsDir() {
+			w.addFile(root, info)
+		} else {
+			w.markVisited(root)
+			w.walkDir(root, 1)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		for _, f := range w.files {
+			key, err := filepath.Abs(f.Path)
+			if err != nil {
+				key = filepath.Clean(f.Path)
+			}
+			if !seen[key] {
+				seen[key] = true
+				files = append(files, f)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+type walker struct {
+	ctx     context.Context
+	root    string
+	opts    WalkOptions
+	files   []JSFile
+	visited map[string]bool // real paths of directories, to break symlink cycles
+}
+
+func (w *walker) walkDir(dir string, depth int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.fail(dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		if !w.opts.IncludeHidden && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if w.matchesAny(w.opts.Exclude, path) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			w.fail(path, err)
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !w.opts.FollowSymlinks {
+				continue
+			}
+			info, err = os.Stat(path)
+			if err != nil {
+				w.fail(path, err)
+				continue
+			}
+		}
+
+		if info.IsDir() {
+			if w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+				continue
+			}
+			if !w.markVisited(path) {
+				continue
+			}
+			w.walkDir(path, depth+1)
+			continue
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if len(w.opts.Include) > 0 && !w.matchesAny(w.opts.Include, path) {
+			continue
+		}
+
+		w.addFile(path, info)
+	}
+}
+
+// addFile records a file without reading it; analysis opens it when it is
+// hashed, so only one chunk per worker is in memory at a time.
+func (w *walker) addFile(path string, info os.FileInfo) {
+	w.files = append(w.files, JSFile{
+		Name:    info.Name(),
+		Path:    path,
+		Size:    info.Size(),
+		Open:    func() (io.ReadCloser, error) { return os.Open(path) },
+		ModTime: info.ModTime().UnixMilli(),
+		Inode:   fileInode(info),
+	})
+}
+
+// markVisited records a directory and reports whether it was new.
+func (w *walker) markVisited(dir string) bool {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		real = dir
+	}
+	if w.visited[real] {
+		return false
+	}
+	w.visited[real] = true
+	return true
+}
+
+func (w *walker) matchesAny(patterns []string, path string) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(path)
+
+	for _, pattern := range patterns {
+		target := base
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := filepath.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *walker) fail(path string, err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(path, err)
+	}
+}
@@ -0,0 +1,32 @@
+package dupes
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCollectFilesListsOverlappingRootsOnce(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "sub/b", "sub/c"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub := filepath.Join(dir, "sub")
+
+	files, err := CollectFiles([]string{sub, dir, dir + "/", filepath.Join(sub, "c")}, WalkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Map(files, func(f JSFile) string { return f.Path })
+	want := []string{filepath.Join(sub, "b"), filepath.Join(sub, "c"), filepath.Join(dir, "a")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package hashdb
+
+import (
+	"context"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// Analyze is dupes.AnalyzeContext backed by db: unchanged files are taken
+// from the database instead of being hashed, and every file that was fully
+// hashed is written back. Trees are returned for all files, in input order.
+func Analyze(ctx context.Context, db *DB, files []dupes.JSFile, opts dupes.Options) (dupes.DedupResult, []dupes.FileTree, error) {
+	chunker := opts.Chunker.Spec()
+
+	var cached []dupes.FileTree
+	for _, f := range files {
+		if ft, ok := db.Lookup(f, chunker, opts.Hash.Name); ok {
+			cached = append(cached, ft)
+		}
+	}
+
+	result, hashed, err := dupes.AnalyzeIncremental(ctx, files, cached, opts)
+	if err != nil {
+		return dupes.DedupResult{}, nil, err
+	}
+
+	byPath := make(map[string]dupes.JSFile, len(files))
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	records := make([]Record, 0, len(hashed))
+	for _, ft := range hashed {
+		if ft.Stage != dupes.StageFull {
+			continue
+		}
+		sig, err := dupes.NewSignature(ft, chunker)
+		if err != nil {
+			return dupes.DedupResult{}, nil, err
+		}
+		f := byPath[ft.Path]
+		records = append(records, Record{Path: f.Path, Size: f.Size, ModTime: f.ModTime, Inode: f.Inode, Signature: sig})
+	}
+	if err := db.Put(records...); err != nil {
+		return dupes.DedupResult{}, nil, err
+	}
+
+	// Hashed trees replace any cached one AnalyzeIncremental passed over
+	treeAt := make(map[string]dupes.FileTree, len(files))
+	for _, ft := range append(cached, hashed...) {
+		treeAt[ft.Path] = ft
+	}
+	trees := dupes.Map(files, func(f dupes.JSFile) dupes.FileTree { return treeAt[f.Path] })
+	return result, trees, nil
+}
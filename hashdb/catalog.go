@@ -0,0 +1,355 @@
+package hashdb
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// ============================================================================
+// REFERENCE CATALOG
+// ============================================================================
+//
+// A catalog is a database built from one reference tree, such as a master
+// archive. Other trees are then checked against it for files it already
+// holds, exactly, partially or visually, without rescanning the reference.
+
+// visualThreshold matches the similarity FindDuplicates uses for media.
+const visualThreshold = 0.85
+
+// Index hashes files into db, skipping those already stored unchanged. It
+// returns how many files were hashed. Every file is fully hashed so the
+// catalog can match anything later. A catalog holds one chunker and hash
+// algorithm, so indexing into one built with others fails before hashing.
+func Index(ctx context.Context, db *DB, files []dupes.JSFile, opts dupes.Options) (int, error) {
+	chunker := opts.Chunker.Spec()
+	if err := checkSettings(db, chunker, opts.Hash.Name); err != nil {
+		return 0, err
+	}
+
+	var changed []dupes.JSFile
+	for _, f := range files {
+		if _, ok := db.Lookup(f, chunker, opts.Hash.Name); !ok {
+			changed = append(changed, f)
+		}
+	}
+
+	trees, err := dupes.HashFiles(ctx, changed, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	records := make([]Record, 0, len(trees))
+	for i, ft := range trees {
+		if ft.Stage != dupes.StageFull {
+			continue
+		}
+		sig, err := dupes.NewSignature(ft, chunker)
+		if err != nil {
+			return 0, err
+		}
+		f := changed[i]
+		records = append(records, Record{Path: f.Path, Size: f.Size, ModTime: f.ModTime, Inode: f.Inode, Signature: sig})
+	}
+	return len(records), db.Put(records...)
+}
+
+// errStop ends a ForEach early.
+var errStop = errors.New("stop")
+
+// checkSettings fails if db already holds signatures built with another
+// chunker or hash algorithm. OpenCatalog keeps every signature consistent,
+// so the first one speaks for all.
+func checkSettings(db *DB, chunker dupes.ChunkerSpec, hashName string) error {
+	err := db.ForEach(func(rec Record) error {
+		if err := rec.Signature.Matches(chunker, hashName); err != nil {
+			return fmt.Errorf("catalog %s was built with other settings: %w", db.path, err)
+		}
+		return errStop
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+// Catalog answers match queries against a database. Exact roots and media
+// hashes are held in memory; chunk lookups use the database's chunk index
+// and candidate signatures are read from disk only when compared.
+type Catalog struct {
+	db      *DB
+	Chunker dupes.ChunkerSpec
+	HashAlg string
+	Files   int
+
+	roots map[string][]string // RootKey → paths
+	media []dupes.FileTree    // images and videos, media fields only
+}
+
+// OpenCatalog loads the indexes of db. Every signature in it must share one
+// chunker and hash algorithm, or roots and leaves would not be comparable.
+func OpenCatalog(db *DB) (*Catalog, error) {
+	if _, err := db.chunkIndex(); err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{db: db, roots: map[string][]string{}}
+	err := db.ForEach(func(rec Record) error {
+		sig := rec.Signature
+		if c.Files == 0 {
+			c.Chunker, c.HashAlg = sig.Chunker, sig.HashAlg
+		} else if err := sig.Matches(c.Chunker, c.HashAlg); err != nil {
+			return fmt.Errorf("catalog mixes signatures: %w", err)
+		}
+		c.Files++
+
+		key := dupes.RootKey(dupes.FileTree{Path: rec.Path, Root: sig.Root, HashAlg: sig.HashAlg, Stage: sig.Stage})
+		c.roots[key] = append(c.roots[key], rec.Path)
+
+		if (sig.IsImage && sig.PHash != 0) || (sig.IsVideo && len(sig.VideoHash) > 0) {
+			c.media = append(c.media, dupes.FileTree{
+				Path:      rec.Path,
+				Size:      rec.Size,
+				PHash:     sig.PHash,
+				IsImage:   sig.IsImage,
+				VideoHash: sig.VideoHash,
+				IsVideo:   sig.IsVideo,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.Files == 0 {
+		return nil, fmt.Errorf("catalog %s is empty", db.path)
+	}
+	return c, nil
+}
+
+// Options returns opts set to the catalog's chunker and hash algorithm, so
+// files checked against it are hashed comparably.
+func (c *Catalog) Options(opts dupes.Options) (dupes.Options, error) {
+	chunker, err := dupes.NewChunker(c.Chunker)
+	if err != nil {
+		return opts, err
+	}
+	hash, err := dupes.LookupHashAlgorithm(c.HashAlg)
+	if err != nil {
+		return opts, err
+	}
+	opts.Chunker = chunker
+	opts.Hash = hash
+	return opts, nil
+}
+
+// Match finds the catalog files ft duplicates: exact roots, partial matches
+// scoring at least opts.Threshold on opts.Metric, and visually similar media.
+// Stop chunks chosen by opts.StopChunks are left out of partial matching, on
+// both sides, with the catalog counting as the set of files. Matches are
+// sorted by similarity, then path.
+func (c *Catalog) Match(ft dupes.FileTree, opts dupes.Options) ([]dupes.DuplicateMatch, error) {
+	threshold, metric := opts.Threshold, opts.Metric
+	matches := []dupes.DuplicateMatch{}
+	seen := make(map[string]bool)
+	addMatch := func(path string, similarity float64, matchType string, shared int64, scores *dupes.SimilarityScores) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		matches = append(matches, dupes.DuplicateMatch{
+			TargetPath: path,
+			Similarity: similarity,
+			SharedSize: shared,
+			MatchType:  matchType,
+			Scores:     scores,
+		})
+	}
+
+	for _, path := range c.roots[dupes.RootKey(ft)] {
+		addMatch(path, 1.0, "exact", ft.Size, nil)
+	}
+
+	stop, err := c.stopChunks(ft, opts.StopChunks)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := c.candidates(dupes.WithoutChunks(ft, stop), metric.CandidateThreshold(threshold))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range candidates {
+		if seen[path] {
+			continue
+		}
+		rec, ok, err := c.db.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		target, err := rec.Signature.FileTree()
+		if err != nil {
+			return nil, err
+		}
+		both, err := c.stopChunks(target, opts.StopChunks)
+		if err != nil {
+			return nil, err
+		}
+		for leaf := range stop {
+			both[leaf] = true
+		}
+		scores := dupes.ScoreFiles(dupes.WithoutChunks(ft, both), dupes.WithoutChunks(target, both))
+		// Only equal roots are exact; those were added above
+		if similarity := metric.Of(scores); similarity >= threshold {
+			addMatch(path, similarity, "partial", int64(float64(ft.Size)*similarity), &scores)
+		}
+	}
+
+	for _, other := range c.media {
+		if similarity := dupes.VisualSimilarity(ft, other); similarity >= visualThreshold {
+			addMatch(other.Path, similarity, "visual", ft.Size, nil)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].TargetPath < matches[j].TargetPath
+	})
+	return matches, nil
+}
+
+// candidates is dupes.FindCandidates over the database's chunk index: paths
+// sharing at least threshold of ft's leaves, in path order. Callers pass
+// SimilarityMetric.CandidateThreshold so no match is pruned.
+func (c *Catalog) candidates(ft dupes.FileTree, threshold float64) ([]string, error) {
+	chunks, err := c.db.chunkIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, leaf := range ft.Leaves {
+		raw, err := hex.DecodeString(leaf)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range chunks[string(raw)] {
+			counts[path]++
+		}
+	}
+
+	minShared := int(float64(len(ft.Leaves)) * threshold)
+	paths := []string{}
+	for path, n := range counts {
+		if n >= minShared {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// stopChunks is the set of ft's leaves that opts ignores: those flagged low
+// entropy and those found in more than opts.MaxFiles catalog files.
+func (c *Catalog) stopChunks(ft dupes.FileTree, opts dupes.StopChunkOptions) (map[string]bool, error) {
+	stop := map[string]bool{}
+	if opts.LowEntropy {
+		for _, i := range ft.LowEntropy {
+			if i >= 0 && i < len(ft.Leaves) {
+				stop[ft.Leaves[i]] = true
+			}
+		}
+	}
+	if opts.MaxFiles <= 0 {
+		return stop, nil
+	}
+
+	chunks, err := c.db.chunkIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, leaf := range ft.Leaves {
+		raw, err := hex.DecodeString(leaf)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunks[string(raw)]) > opts.MaxFiles {
+			stop[leaf] = true
+		}
+	}
+	return stop, nil
+}
+
+// CatalogMatch is how one checked file relates to the catalog.
+type CatalogMatch struct {
+	Path    string
+	Size    int64
+	Status  string // best match type: "exact", "partial", "visual", or "new"; "error" if unreadable
+	Error   string `json:",omitempty"`
+	Matches []dupes.DuplicateMatch
+}
+
+// Check hashes files with the catalog's chunker and hash algorithm and
+// matches each one against the catalog. Results are in input order. Files
+// that cannot be read get status "error" rather than passing for new.
+func (c *Catalog) Check(ctx context.Context, files []dupes.JSFile, opts dupes.Options) ([]CatalogMatch, error) {
+	opts, err := c.Options(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	readErrs := make(map[string]error)
+	onError := opts.OnError
+	opts.OnError = func(path string, err error) {
+		mu.Lock()
+		readErrs[path] = err
+		mu.Unlock()
+		if onError != nil {
+			onError(path, err)
+		}
+	}
+
+	trees, err := dupes.HashFiles(ctx, files, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CatalogMatch, len(trees))
+	for i, ft := range trees {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ft.Stage == dupes.StageError {
+			results[i] = CatalogMatch{Path: ft.Path, Size: ft.Size, Status: "error", Error: fmt.Sprint(readErrs[ft.Path])}
+			continue
+		}
+		matches, err := c.Match(ft, opts)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = CatalogMatch{Path: ft.Path, Size: ft.Size, Status: bestMatchType(matches), Matches: matches}
+	}
+	return results, nil
+}
+
+// bestMatchType ranks exact over partial over visual.
+func bestMatchType(matches []dupes.DuplicateMatch) string {
+	best := "new"
+	rank := map[string]int{"new": 0, "visual": 1, "partial": 2, "exact": 3}
+	for _, m := range matches {
+		if rank[m.MatchType] > rank[best] {
+			best = m.MatchType
+		}
+	}
+	return best
+}
@@ -0,0 +1,120 @@
+package hashdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+func openTemp(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "hashes.db"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestIndexRejectsOtherSettings(t *testing.T) {
+	db := openTemp(t)
+	files := []dupes.JSFile{memFile("/ref/a", strings.Repeat("a", 10000))}
+
+	opts := dupes.DefaultOptions()
+	if _, err := Index(context.Background(), db, files, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	other := opts
+	other.Chunker = dupes.FixedChunker{Size: 1024}
+	more := []dupes.JSFile{memFile("/ref/b", "b")}
+	if _, err := Index(context.Background(), db, more, other); !errors.Is(err, dupes.ErrIncompatibleSignature) {
+		t.Fatalf("Index with another chunker: %v, want ErrIncompatibleSignature", err)
+	}
+	if _, err := OpenCatalog(db); err != nil {
+		t.Errorf("catalog unusable after a rejected Index: %v", err)
+	}
+}
+
+func TestCheckReportsUnreadableFiles(t *testing.T) {
+	db := openTemp(t)
+	ref := memFile("/ref/a", strings.Repeat("reference ", 1000))
+	if _, err := Index(context.Background(), db, []dupes.JSFile{ref}, dupes.DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := memFile("/new/broken", "")
+	broken.Size = 100
+	broken.Open = func() (io.ReadCloser, error) { return nil, errors.New("permission denied") }
+	files := []dupes.JSFile{memFile("/new/copy", strings.Repeat("reference ", 1000)), broken, memFile("/new/other", "other")}
+
+	results, err := catalog.Check(context.Background(), files, dupes.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := dupes.Map(results, func(r CatalogMatch) string { return r.Status })
+	if want := []string{"exact", "error", "new"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if !strings.Contains(results[1].Error, "permission denied") {
+		t.Errorf("error = %q, want the read error", results[1].Error)
+	}
+}
+
+func TestCheckIgnoresStopChunks(t *testing.T) {
+	header := ""
+	for i := 0; len(header) < 30*256; i++ {
+		header += fmt.Sprintf("shared header line %d\n", i)
+	}
+	header = header[:30*256]
+	zeros := strings.Repeat("\x00", 30*256)
+	own := func(name string) string { return strings.Repeat(name+" own text ", 52)[:2*256] }
+
+	db := openTemp(t)
+	opts := dupes.DefaultOptions()
+	opts.Chunker = dupes.FixedChunker{Size: 256}
+	refs := []dupes.JSFile{
+		memFile("/ref/zeros", zeros+own("zeros")),
+		memFile("/ref/h1", header+own("h1")),
+		memFile("/ref/h2", header+own("h2")),
+		memFile("/ref/h3", header+own("h3")),
+	}
+	if _, err := Index(context.Background(), db, refs, opts); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := OpenCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []dupes.JSFile{memFile("/new/zeros", zeros+own("new")), memFile("/new/header", header+own("new"))}
+
+	for _, tc := range []struct {
+		stop dupes.StopChunkOptions
+		want string
+	}{
+		{dupes.StopChunkOptions{}, "partial,partial"},
+		{dupes.StopChunkOptions{LowEntropy: true}, "new,partial"},
+		{dupes.StopChunkOptions{LowEntropy: true, MaxFiles: 2}, "new,new"},
+		{dupes.StopChunkOptions{MaxFiles: 3}, "partial,partial"},
+	} {
+		opts.StopChunks = tc.stop
+		results, err := catalog.Check(context.Background(), files, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := dupes.Map(results, func(r CatalogMatch) string { return r.Status })
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("%+v: statuses = %v, want %s", tc.stop, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,572 @@
+// Package hashdb is a persistent cache of FileTree signatures for the native
+// builds. Repeated scans look files up by path, size, modification time and
+// inode and only rehash the ones that changed.
+//
+// The database is a single append-only log. Every Put or Delete appends a
+// checksummed record; the latest record for a path wins. Open replays the log
+// into an in-memory index of offsets, so signatures are only read from disk
+// when asked for, and Compact rewrites the log with just the live records.
+package hashdb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// ============================================================================
+// LOG FORMAT
+// ============================================================================
+
+// The log starts with logMagic and a version byte. Each record is
+//
+//	uint32 payload length | uint32 CRC-32C of payload | payload
+//
+// and a payload is an op byte, the uvarint-prefixed path and, for puts, the
+// varint size, varint modification time, uvarint inode and the binary
+// signature filling the rest.
+var logMagic = []byte("PDDB")
+
+const (
+	logVersion = 1
+	headerSize = 5
+	recordHead = 8
+
+	opPut    = 1
+	opDelete = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrLocked is returned by Open when another process holds the database.
+var ErrLocked = errors.New("database is in use by another process")
+
+// ============================================================================
+// DATABASE
+// ============================================================================
+
+// Options controls Open.
+type Options struct {
+	// ReadOnly opens an existing database without write access. Any number of
+	// read-only handles may be open at once; a writable handle excludes all
+	// others.
+	ReadOnly bool
+}
+
+// Record is one cached file.
+type Record struct {
+	Path      string
+	Size      int64
+	ModTime   int64 // Unix milliseconds, as in dupes.JSFile
+	Inode     uint64
+	Signature dupes.Signature
+}
+
+type entry struct {
+	size    int64
+	modTime int64
+	inode   uint64
+	sigOff  int64 // where the signature starts in the log
+	sigLen  int
+	recLen  int64 // whole record, for garbage accounting
+}
+
+// DB is an open database. It is safe for concurrent use; reads run in
+// parallel and writes are serialized.
+type DB struct {
+	path     string
+	readOnly bool
+
+	mu      sync.RWMutex
+	f       *os.File
+	end     int64
+	index   map[string]entry
+	records int   // records in the log, live or not
+	live    int64 // bytes of records still in the index
+
+	// chunks maps raw chunk hashes to the paths containing them. It is built
+	// on first use and dropped on every write.
+	chunks map[string][]string
+}
+
+// Open opens the database at path, creating it unless opts.ReadOnly is set.
+// A log cut short by a crash is truncated back to its last whole record.
+func Open(path string, opts Options) (*DB, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flags = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, !opts.ReadOnly); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	db := &DB{path: path, readOnly: opts.ReadOnly, f: f}
+	if err := db.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// load replays the log into the index.
+func (db *DB) load() error {
+	info, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		if db.readOnly {
+			return errors.New("empty database")
+		}
+		header := append(append([]byte{}, logMagic...), logVersion)
+		if _, err := db.f.WriteAt(header, 0); err != nil {
+			return err
+		}
+		db.end = headerSize
+		db.index = map[string]entry{}
+		return db.f.Sync()
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := db.f.ReadAt(header, 0); err != nil {
+		return errors.New("not a pure-dupes hash database")
+	}
+	if string(header[:4]) != string(logMagic) {
+		return errors.New("not a pure-dupes hash database")
+	}
+	if header[4] != logVersion {
+		return fmt.Errorf("unsupported database version %d", header[4])
+	}
+
+	db.index = map[string]entry{}
+	r := io.NewSectionReader(db.f, 0, info.Size())
+	off := int64(headerSize)
+	head := make([]byte, recordHead)
+	var payload []byte
+
+	for {
+		if _, err := r.ReadAt(head, off); err != nil {
+			break
+		}
+		n := int64(binary.BigEndian.Uint32(head))
+		if off+recordHead+n > info.Size() {
+			break
+		}
+		if int64(cap(payload)) < n {
+			payload = make([]byte, n)
+		}
+		payload = payload[:n]
+		if _, err := r.ReadAt(payload, off+recordHead); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(head[4:]) {
+			break
+		}
+		if err := db.apply(payload, off); err != nil {
+			return err
+		}
+		off += recordHead + n
+	}
+
+	db.end = off
+	if off < info.Size() && !db.readOnly {
+		// Drop the torn tail so the next append starts on a record boundary
+		if err := db.f.Truncate(off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply updates the index with the record whose payload starts at off+recordHead.
+func (db *DB) apply(payload []byte, off int64) error {
+	recLen := int64(recordHead + len(payload))
+	op, path, rest, err := decodeKey(payload)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := db.index[path]; ok {
+		db.live -= old.recLen
+		delete(db.index, path)
+	}
+	db.records++
+
+	if op == opDelete {
+		return nil
+	}
+
+	e := entry{recLen: recLen}
+	var n int
+	if e.size, n = binary.Varint(rest); n <= 0 {
+		return fmt.Errorf("corrupt record at offset %d", off)
+	}
+	rest = rest[n:]
+	if e.modTime, n = binary.Varint(rest); n <= 0 {
+		return fmt.Errorf("corrupt record at offset %d", off)
+	}
+	rest = rest[n:]
+	if e.inode, n = binary.Uvarint(rest); n <= 0 {
+		return fmt.Errorf("corrupt record at offset %d", off)
+	}
+	rest = rest[n:]
+
+	e.sigLen = len(rest)
+	e.sigOff = off + recLen - int64(len(rest))
+	db.index[path] = e
+	db.live += recLen
+	return nil
+}
+
+func decodeKey(payload []byte) (byte, string, []byte, error) {
+	if len(payload) < 1 {
+		return 0, "", nil, errors.New("empty record")
+	}
+	op := payload[0]
+	if op != opPut && op != opDelete {
+		return 0, "", nil, fmt.Errorf("unknown record type %d", op)
+	}
+	n, k := binary.Uvarint(payload[1:])
+	if k <= 0 || n > uint64(len(payload)-1-k) {
+		return 0, "", nil, errors.New("corrupt record key")
+	}
+	start := 1 + k
+	return op, string(payload[start : start+int(n)]), payload[start+int(n):], nil
+}
+
+// Close releases the database.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return nil
+	}
+	err := db.f.Close()
+	db.f = nil
+	return err
+}
+
+// Get returns the record stored for path.
+func (db *DB) Get(path string) (Record, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	e, ok := db.index[path]
+	if !ok {
+		return Record{}, false, nil
+	}
+	sig, err := db.readSignature(e)
+	if err != nil {
+		return Record{}, false, fmt.Errorf("%s: %v", path, err)
+	}
+	return Record{Path: path, Size: e.size, ModTime: e.modTime, Inode: e.inode, Signature: sig}, true, nil
+}
+
+// Lookup returns the cached tree for f if f is unchanged since it was stored
+// and its signature was built with chunker and hashName.
+func (db *DB) Lookup(f dupes.JSFile, chunker dupes.ChunkerSpec, hashName string) (dupes.FileTree, bool) {
+	db.mu.RLock()
+	e, ok := db.index[f.Path]
+	if !ok || e.size != f.Size || e.modTime != f.ModTime || e.inode != f.Inode {
+		db.mu.RUnlock()
+		return dupes.FileTree{}, false
+	}
+	sig, err := db.readSignature(e)
+	db.mu.RUnlock()
+
+	if err != nil || sig.Stage != dupes.StageFull || sig.Matches(chunker, hashName) != nil {
+		return dupes.FileTree{}, false
+	}
+	ft, err := sig.FileTree()
+	if err != nil {
+		return dupes.FileTree{}, false
+	}
+	return ft, true
+}
+
+// readSignature reads and decodes e's signature. Callers hold mu.
+func (db *DB) readSignature(e entry) (dupes.Signature, error) {
+	buf := make([]byte, e.sigLen)
+	if _, err := db.f.ReadAt(buf, e.sigOff); err != nil {
+		return dupes.Signature{}, err
+	}
+	var sig dupes.Signature
+	err := sig.UnmarshalBinary(buf)
+	return sig, err
+}
+
+// Put stores records, replacing any earlier ones for the same paths, and
+// syncs the log once for the whole batch.
+func (db *DB) Put(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, rec := range records {
+		sig, err := rec.Signature.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		payload := appendKey(nil, opPut, rec.Path)
+		payload = binary.AppendVarint(payload, rec.Size)
+		payload = binary.AppendVarint(payload, rec.ModTime)
+		payload = binary.AppendUvarint(payload, rec.Inode)
+		payload = append(payload, sig...)
+		buf = appendRecord(buf, payload)
+	}
+	return db.append(buf)
+}
+
+// Delete removes paths from the database.
+func (db *DB) Delete(paths ...string) error {
+	var buf []byte
+	for _, path := range paths {
+		buf = appendRecord(buf, appendKey(nil, opDelete, path))
+	}
+	return db.append(buf)
+}
+
+func appendKey(buf []byte, op byte, path string) []byte {
+	buf = append(buf, op)
+	buf = binary.AppendUvarint(buf, uint64(len(path)))
+	return append(buf, path...)
+}
+
+func appendRecord(buf, payload []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// append writes encoded records at the end of the log and indexes them.
+func (db *DB) append(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if db.readOnly {
+		return errors.New("database is read-only")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return os.ErrClosed
+	}
+
+	if _, err := db.f.WriteAt(buf, db.end); err != nil {
+		// Forget whatever part of the batch made it out
+		db.f.Truncate(db.end)
+		return err
+	}
+	if err := db.f.Sync(); err != nil {
+		return err
+	}
+
+	off := db.end
+	for rest := buf; len(rest) > 0; {
+		n := int(binary.BigEndian.Uint32(rest))
+		if err := db.apply(rest[recordHead:recordHead+n], off); err != nil {
+			return err
+		}
+		off += int64(recordHead + n)
+		rest = rest[recordHead+n:]
+	}
+	db.end = off
+	db.chunks = nil
+	return nil
+}
+
+// ============================================================================
+// CHUNK INDEX
+// ============================================================================
+
+// FilesWithChunk returns the paths whose signatures contain the chunk with
+// the given hex hash, in sorted order.
+func (db *DB) FilesWithChunk(chunkHash string) ([]string, error) {
+	raw, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := db.chunkIndex()
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), chunks[string(raw)]...), nil
+}
+
+// chunkIndex returns the chunk → paths index, building it if needed.
+func (db *DB) chunkIndex() (map[string][]string, error) {
+	db.mu.RLock()
+	chunks := db.chunks
+	db.mu.RUnlock()
+	if chunks != nil {
+		return chunks, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.chunks != nil {
+		return db.chunks, nil
+	}
+
+	chunks = map[string][]string{}
+	err := db.forEach(func(rec Record) error {
+		seen := make(map[string]bool, len(rec.Signature.Leaves))
+		for _, leaf := range rec.Signature.Leaves {
+			if key := string(leaf); !seen[key] {
+				seen[key] = true
+				chunks[key] = append(chunks[key], rec.Path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	db.chunks = chunks
+	return chunks, nil
+}
+
+// forEach calls fn with every record in path order. Callers hold mu.
+func (db *DB) forEach(fn func(Record) error) error {
+	for _, path := range db.sortedPaths() {
+		e := db.index[path]
+		sig, err := db.readSignature(e)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		rec := Record{Path: path, Size: e.size, ModTime: e.modTime, Inode: e.inode, Signature: sig}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEach calls fn with every record in path order, stopping at the first
+// error. fn must not write to the database.
+func (db *DB) ForEach(fn func(Record) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.forEach(fn)
+}
+
+// sortedPaths lists indexed paths in order. Callers hold mu.
+func (db *DB) sortedPaths() []string {
+	paths := make([]string, 0, len(db.index))
+	for path := range db.index {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ============================================================================
+// STATS AND COMPACTION
+// ============================================================================
+
+// Stats describes the database file.
+type Stats struct {
+	Path      string
+	Files     int     // live records
+	Records   int     // records in the log, including replaced and deleted ones
+	FileBytes int64   // size of the log
+	LiveBytes int64   // bytes a compaction would keep, header included
+	Garbage   float64 // fraction of the log a compaction would reclaim
+	Chunks    int     // distinct chunk hashes across live signatures
+}
+
+// Stats reports the database size and how much of it is garbage.
+func (db *DB) Stats() (Stats, error) {
+	chunks, err := db.chunkIndex()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	s := Stats{
+		Path:      db.path,
+		Files:     len(db.index),
+		Records:   db.records,
+		FileBytes: db.end,
+		LiveBytes: headerSize + db.live,
+		Chunks:    len(chunks),
+	}
+	if s.FileBytes > 0 {
+		s.Garbage = 1 - float64(s.LiveBytes)/float64(s.FileBytes)
+	}
+	return s, nil
+}
+
+// Compact rewrites the log with only live records, in path order, and
+// atomically replaces the old file.
+func (db *DB) Compact() error {
+	if db.readOnly {
+		return errors.New("database is read-only")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return os.ErrClosed
+	}
+
+	tmpPath := db.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := lockFile(tmp, true); err != nil {
+		return fail(err)
+	}
+
+	header := append(append([]byte{}, logMagic...), logVersion)
+	if _, err := tmp.Write(header); err != nil {
+		return fail(err)
+	}
+	for _, path := range db.sortedPaths() {
+		e := db.index[path]
+		rec := make([]byte, e.recLen)
+		if _, err := db.f.ReadAt(rec, e.sigOff+int64(e.sigLen)-e.recLen); err != nil {
+			return fail(err)
+		}
+		if _, err := tmp.Write(rec); err != nil {
+			return fail(err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, db.path); err != nil {
+		return fail(err)
+	}
+
+	db.f.Close()
+	db.f = tmp
+	db.index = nil
+	db.records, db.live = 0, 0
+	db.chunks = nil
+	return db.load()
+}
@@ -0,0 +1,211 @@
+package hashdb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// memFile is an in-memory JSFile.
+func memFile(path, content string) dupes.JSFile {
+	return dupes.JSFile{Name: filepath.Base(path), Path: path, Size: int64(len(content)), Data: []byte(content), ModTime: 1}
+}
+
+var testChunker = dupes.FixedChunker{Size: 64}
+
+// record hashes content and wraps it as a Record for path.
+func record(t *testing.T, path, content string) Record {
+	t.Helper()
+	f := memFile(path, content)
+	ft, err := dupes.ProcessFile(f, testChunker, dupes.DefaultOptions().Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := dupes.NewSignature(ft, testChunker.Spec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Record{Path: path, Size: f.Size, ModTime: f.ModTime, Inode: 7, Signature: sig}
+}
+
+// reopen closes db and opens the file at path again.
+func reopen(t *testing.T, db *DB, path string) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDBRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	db, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := record(t, "/d/a", strings.Repeat("a", 300))
+	b := record(t, "/d/b", strings.Repeat("b", 100))
+	if err := db.Put(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("/d/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopen(t, db, path)
+	got, ok, err := db.Get("/d/a")
+	if err != nil || !ok {
+		t.Fatalf("Get(/d/a) = %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(got, a) {
+		t.Errorf("Get(/d/a):\ngot  %+v\nwant %+v", got, a)
+	}
+	if _, ok, _ := db.Get("/d/b"); ok {
+		t.Error("deleted record came back after reopening")
+	}
+
+	f := memFile("/d/a", strings.Repeat("a", 300))
+	f.Inode = 7
+	if _, ok := db.Lookup(f, testChunker.Spec(), "sha256"); !ok {
+		t.Error("Lookup missed an unchanged file")
+	}
+	f.ModTime++
+	if _, ok := db.Lookup(f, testChunker.Spec(), "sha256"); ok {
+		t.Error("Lookup hit a file with a new modification time")
+	}
+}
+
+func TestDBDropsTornAndCorruptRecords(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"torn tail", func(data []byte) []byte { return data[:len(data)-3] }},
+		{"bad checksum", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			return data
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hashes.db")
+			db, err := Open(path, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := record(t, "/d/a", "first")
+			if err := db.Put(a); err != nil {
+				t.Fatal(err)
+			}
+			good, _ := os.Stat(path)
+			if err := db.Put(record(t, "/d/b", "second")); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, c.corrupt(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = Open(path, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, err := db.Get("/d/a"); !ok || err != nil {
+				t.Errorf("record before the damage lost: %v, %v", ok, err)
+			}
+			if _, ok, _ := db.Get("/d/b"); ok {
+				t.Error("damaged record was loaded")
+			}
+			if info, _ := os.Stat(path); info.Size() != good.Size() {
+				t.Errorf("log is %d bytes, want it cut back to %d", info.Size(), good.Size())
+			}
+
+			// Appends after recovery land on a record boundary
+			third := record(t, "/d/c", "third")
+			if err := db.Put(third); err != nil {
+				t.Fatal(err)
+			}
+			db = reopen(t, db, path)
+			if got, ok, err := db.Get("/d/c"); !ok || err != nil || !reflect.DeepEqual(got, third) {
+				t.Errorf("record written after recovery: %+v, %v, %v", got, ok, err)
+			}
+		})
+	}
+}
+
+func TestDBRejectsForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"other":  "not a database at all",
+		"future": "PDDB\x09",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if db, err := Open(path, Options{}); err == nil {
+			db.Close()
+			t.Errorf("%s: opened", name)
+		}
+	}
+}
+
+func TestDBCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	db, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	a := record(t, "/d/a", strings.Repeat("new a", 40))
+	b := record(t, "/d/b", "b")
+	for _, err := range []error{
+		db.Put(record(t, "/d/a", "old a"), b),
+		db.Put(a, record(t, "/d/gone", "gone")),
+		db.Delete("/d/gone"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Files != 2 || before.Records != 5 || before.Garbage <= 0 {
+		t.Errorf("before compaction: %+v", before)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Files != 2 || after.Records != 2 || after.Garbage != 0 || after.FileBytes != before.LiveBytes {
+		t.Errorf("after compaction: %+v (LiveBytes before %d)", after, before.LiveBytes)
+	}
+
+	db = reopen(t, db, path)
+	for _, want := range []Record{a, b} {
+		if got, ok, err := db.Get(want.Path); !ok || err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s after compaction: %+v, %v, %v", want.Path, got, ok, err)
+		}
+	}
+}
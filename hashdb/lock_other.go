@@ -0,0 +1,11 @@
+//go:build !unix
+
+package hashdb
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable; callers must not share a
+// database between processes there.
+func lockFile(*os.File, bool) error {
+	return nil
+}
@@ -1,15 +1,16 @@
+//go:build js && wasm
+
 // main_wasm.go - Enhanced with progress reporting
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
-	"path/filepath"
-	"strings"
+	"io"
 	"syscall/js"
-	"time"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
 )
 
 // ============================================================================
@@ -30,982 +31,424 @@ func reportProgress(current, total int, message string, percent float64) {
 }
 
 // ============================================================================
-// MONOID
+// STREAMING INPUT
 // ============================================================================
 
-type Monoid[A any] struct {
-	Empty   func() A
-	Combine func(A, A) A
-}
-
-func (m Monoid[A]) Fold(xs []A) A {
-	return FoldLeft(xs, m.Empty(), m.Combine)
+// blobReader pulls a file's content from JavaScript a slice at a time. read
+// is a JS function (offset, length) => Uint8Array, typically a Blob.slice
+// read with FileReaderSync inside the worker, so only the slice being hashed
+// is ever copied into Go memory.
+type blobReader struct {
+	read   js.Value
+	size   int64
+	offset int64
 }
 
-var SHA256Monoid = Monoid[[]byte]{
-	Empty: func() []byte { return []byte{} },
-	Combine: func(a, b []byte) []byte {
-		h := sha256.New()
-		h.Write(a)
-		h.Write(b)
-		return h.Sum(nil)
-	},
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.ReadAt(p, b.offset)
+	b.offset += int64(n)
+	return n, err
 }
 
-func MapMonoid[K comparable, V any](vm Monoid[V]) Monoid[map[K]V] {
-	return Monoid[map[K]V]{
-		Empty: func() map[K]V { return make(map[K]V) },
-		Combine: func(a, b map[K]V) map[K]V {
-			result := make(map[K]V)
-			for k, v := range a {
-				result[k] = v
-			}
-			for k, v := range b {
-				if existing, ok := result[k]; ok {
-					result[k] = vm.Combine(existing, v)
-				} else {
-					result[k] = v
-				}
-			}
-			return result
-		},
+func (b *blobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
 	}
-}
-
-func SliceMonoid[A any]() Monoid[[]A] {
-	return Monoid[[]A]{
-		Empty: func() []A { return []A{} },
-		Combine: func(a, b []A) []A {
-			result := make([]A, 0, len(a)+len(b))
-			result = append(result, a...)
-			result = append(result, b...)
-			return result
-		},
+	want := int64(len(p))
+	if rest := b.size - off; want > rest {
+		want = rest
 	}
-}
 
-// ============================================================================
-// FOLD OPERATIONS
-// ============================================================================
-
-func FoldLeft[A, B any](xs []A, zero B, f func(B, A) B) B {
-	acc := zero
-	for _, x := range xs {
-		acc = f(acc, x)
-	}
-	return acc
-}
-
-func FoldRight[A, B any](xs []A, zero B, f func(A, B) B) B {
-	acc := zero
-	for i := len(xs) - 1; i >= 0; i-- {
-		acc = f(xs[i], acc)
+	slice := b.read.Invoke(float64(off), float64(want))
+	n := js.CopyBytesToGo(p[:want], slice)
+	if n == 0 && want > 0 {
+		return 0, io.ErrUnexpectedEOF
 	}
-	return acc
-}
-
-func FoldMap[A, B any](xs []A, m Monoid[B], f func(A) B) B {
-	return m.Fold(Map(xs, f))
-}
-
-// ============================================================================
-// FUNCTOR INSTANCES
-// ============================================================================
-
-type Maybe[A any] struct {
-	value   A
-	present bool
-}
-
-func Just[A any](v A) Maybe[A] {
-	return Maybe[A]{value: v, present: true}
-}
-
-func Nothing[A any]() Maybe[A] {
-	return Maybe[A]{present: false}
-}
-
-func (m Maybe[A]) FMap(f func(A) A) Maybe[A] {
-	if !m.present {
-		return Nothing[A]()
+	if int64(n) < int64(len(p)) {
+		return n, io.EOF
 	}
-	return Just(f(m.value))
+	return n, nil
 }
 
-func (m Maybe[A]) IsPresent() bool {
-	return m.present
-}
-
-func (m Maybe[A]) Get() A {
-	return m.value
-}
+func (b *blobReader) Close() error { return nil }
 
 // ============================================================================
-// UTILITY FUNCTIONS
+// WASM EXPORTS
 // ============================================================================
 
-func Map[A, B any](xs []A, f func(A) B) []B {
-	return FoldRight(xs, []B{}, func(a A, acc []B) []B {
-		return append([]B{f(a)}, acc...)
-	})
-}
-
-func Filter[A any](xs []A, pred func(A) bool) []A {
-	return FoldRight(xs, []A{}, func(a A, acc []A) []A {
-		if pred(a) {
-			return append([]A{a}, acc...)
+func analyzeFiles(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return map[string]interface{}{
+			"error": "Expected 3 arguments: files, threshold, chunkSize",
 		}
-		return acc
-	})
-}
-
-func GroupBy[A any, K comparable](xs []A, key func(A) K) map[K][]A {
-	return FoldLeft(xs, make(map[K][]A), func(acc map[K][]A, x A) map[K][]A {
-		k := key(x)
-		acc[k] = append(acc[k], x)
-		return acc
-	})
-}
-
-// ============================================================================
-// DOMAIN TYPES
-// ============================================================================
-
-type MerkleNode struct {
-	Hash     []byte
-	Children []MerkleNode
-	IsLeaf   bool
-}
-
-type FileTree struct {
-	Path       string
-	Root       []byte
-	Tree       MerkleNode
-	Size       int64
-	ChunkCount int
-	Leaves     []string
-	ModTime    int64
-	PHash      uint64   // Phase 2: Image perceptual hash
-	IsImage    bool     // Phase 2: Is this an image file?
-	VideoHash  []uint64 // Phase 2: Video frame hashes (array of pHashes)
-	IsVideo    bool     // Phase 2: Is this a video file?
-}
-
-type DuplicateMatch struct {
-	TargetPath string
-	Similarity float64
-	SharedSize int64
-	MatchType  string // "exact", "partial", "content"
-}
-
-type FileNode struct {
-	Path         string
-	Name         string
-	IsDir        bool
-	Children     []FileNode
-	Matches      []DuplicateMatch
-	BestMatch    float64
-	Size         int64
-	RelativePath string
-}
-
-type DuplicateGroup struct {
-	Files      []string
-	Similarity float64
-	Size       int64
-	GroupType  string // "exact", "similar"
-	Savings    int64
-}
-
-type DedupResult struct {
-	RootTree        FileNode
-	AllMatches      map[string][]DuplicateMatch
-	DuplicateGroups []DuplicateGroup
-	TotalFiles      int
-	UniqueFiles     int
-	FullDupCount    int
-	PartialDupCount int
-	VisualDupCount  int // Phase 2: Visual duplicate count
-	SpaceSaved      int64
-	ProcessingTime  float64
-}
-
-type JSFile struct {
-	Name             string
-	Path             string
-	Size             int64
-	Data             []byte
-	ModTime          int64
-	VideoFrameHashes []uint64 // Phase 2: Video frame hashes from JavaScript
-}
-
-// ============================================================================
-// MERKLE TREE
-// ============================================================================
-
-func HashLeaf(data []byte) []byte {
-	h := sha256.Sum256(data)
-	return h[:]
-}
-
-func BuildMerkleTree(hashes [][]byte, m Monoid[[]byte]) MerkleNode {
-	if len(hashes) == 0 {
-		return MerkleNode{Hash: m.Empty(), IsLeaf: true, Children: []MerkleNode{}}
-	}
-	if len(hashes) == 1 {
-		return MerkleNode{Hash: hashes[0], IsLeaf: true, Children: []MerkleNode{}}
-	}
-
-	pairs := pairwiseFold(hashes, m)
-
-	if len(pairs) == 1 {
-		return pairs[0]
-	}
-
-	parentHashes := Map(pairs, func(n MerkleNode) []byte { return n.Hash })
-	upperTree := BuildMerkleTree(parentHashes, m)
-	upperTree.Children = pairs
-	return upperTree
-}
-
-func pairwiseFold(hashes [][]byte, m Monoid[[]byte]) []MerkleNode {
-	type Acc struct {
-		nodes   []MerkleNode
-		pending Maybe[[]byte]
-	}
-
-	result := FoldLeft(hashes, Acc{nodes: []MerkleNode{}, pending: Nothing[[]byte]()},
-		func(acc Acc, hash []byte) Acc {
-			if !acc.pending.IsPresent() {
-				return Acc{nodes: acc.nodes, pending: Just(hash)}
-			}
-
-			left := MerkleNode{Hash: acc.pending.Get(), IsLeaf: true, Children: []MerkleNode{}}
-			right := MerkleNode{Hash: hash, IsLeaf: true, Children: []MerkleNode{}}
-			combined := m.Combine(acc.pending.Get(), hash)
-
-			parent := MerkleNode{
-				Hash:     combined,
-				IsLeaf:   false,
-				Children: []MerkleNode{left, right},
-			}
-
-			return Acc{nodes: append(acc.nodes, parent), pending: Nothing[[]byte]()}
-		})
-
-	if result.pending.IsPresent() {
-		single := MerkleNode{Hash: result.pending.Get(), IsLeaf: true, Children: []MerkleNode{}}
-		return append(result.nodes, single)
 	}
 
-	return result.nodes
-}
-
-func collectLeaves(node MerkleNode) [][]byte {
-	if node.IsLeaf {
-		return [][]byte{node.Hash}
-	}
-
-	leafMonoid := SliceMonoid[[]byte]()
-	return FoldMap(node.Children, leafMonoid, collectLeaves)
-}
-
-// ============================================================================
-// FILE PROCESSING
-// ============================================================================
-
-func ProcessFile(file JSFile, chunkSize int, index int, total int) FileTree {
-	reportProgress(index, total, fmt.Sprintf("Processing %s", file.Name), float64(index)/float64(total)*100)
-
-	data := file.Data
-	chunks := [][]byte{}
-
-	for i := 0; i < len(data); i += chunkSize {
-		end := i + chunkSize
-		if end > len(data) {
-			end = len(data)
+	opts, err := parseOptions(args[1:])
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
 		}
-		chunks = append(chunks, data[i:end])
 	}
 
-	hashes := Map(chunks, HashLeaf)
-	tree := BuildMerkleTree(hashes, SHA256Monoid)
-	root := tree.Hash
-
-	leafBytes := collectLeaves(tree)
-	leaves := Map(leafBytes, func(b []byte) string {
-		return hex.EncodeToString(b)
-	})
+	// Run deduplication
+	result := dupes.FindDuplicates(convertFiles(args[0]), opts)
 
-	// Phase 2: Compute pHash for images
-	var pHash uint64
-	isImage := isImageFile(file.Path)
-	if isImage {
-		hash, err := computePHash(data)
-		if err == nil {
-			pHash = hash
+	// Convert result to JSON
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Failed to marshal result: %v", err),
 		}
 	}
 
-	// Phase 2: Video frame hashes (computed by JavaScript)
-	var videoHash []uint64
-	isVideo := isVideoFile(file.Path)
-	if isVideo && len(file.VideoFrameHashes) > 0 {
-		videoHash = file.VideoFrameHashes
-	}
-
-	return FileTree{
-		Path:       file.Path,
-		Root:       root,
-		Tree:       tree,
-		Size:       file.Size,
-		ChunkCount: len(chunks),
-		Leaves:     leaves,
-		ModTime:    file.ModTime,
-		PHash:      pHash,
-		IsImage:    isImage,
-		VideoHash:  videoHash,
-		IsVideo:    isVideo,
-	}
-}
-
-// ============================================================================
-// DEDUPLICATION
-// ============================================================================
-
-func BuildChunkIndex(files []FileTree) map[string][]int {
-	reportProgress(0, 100, "Building chunk index...", 0)
-
-	indexMonoid := MapMonoid[string, []int](SliceMonoid[int]())
-
-	result := FoldMap(files, indexMonoid, func(ft FileTree) map[string][]int {
-		fileIdx := findFileIndex(files, ft)
-		return FoldLeft(ft.Leaves, make(map[string][]int), func(acc map[string][]int, chunkHash string) map[string][]int {
-			acc[chunkHash] = []int{fileIdx}
-			return acc
-		})
-	})
-
-	reportProgress(100, 100, "Chunk index built", 100)
-	return result
+	return string(jsonBytes)
 }
 
-func findFileIndex(files []FileTree, target FileTree) int {
-	for i, f := range files {
-		if f.Path == target.Path {
-			return i
+// analyzeIncremental is analyzeFiles with signatures cached from earlier runs
+// as the second argument (an array or its JSON). Files with a usable cached
+// signature are only grouped and matched; the others are hashed, and the
+// result carries their signatures so the page can cache them in turn.
+// Signatures rather than FileTrees cross into JavaScript because their JSON
+// keeps 64-bit hashes as strings, which JSON.parse would otherwise round.
+func analyzeIncremental(this js.Value, args []js.Value) interface{} {
+	if len(args) < 4 {
+		return map[string]interface{}{
+			"error": "Expected 4 arguments: files, cachedSignatures, threshold, chunkSize",
 		}
 	}
-	return -1
-}
 
-func FindCandidates(sourceFile FileTree, chunkIndex map[string][]int, threshold float64) map[int]int {
-	countMap := FoldLeft(sourceFile.Leaves, make(map[int]int),
-		func(acc map[int]int, chunkHash string) map[int]int {
-			if targets, exists := chunkIndex[chunkHash]; exists {
-				for _, targetIdx := range targets {
-					acc[targetIdx]++
-				}
-			}
-			return acc
-		})
-
-	minSharedChunks := int(float64(len(sourceFile.Leaves)) * threshold)
-
-	return FoldLeft(mapToSlice(countMap), make(map[int]int),
-		func(acc map[int]int, pair struct {
-			k int
-			v int
-		}) map[int]int {
-			if pair.v >= minSharedChunks {
-				acc[pair.k] = pair.v
-			}
-			return acc
-		})
-}
-
-func mapToSlice[K comparable, V any](m map[K]V) []struct {
-	k K
-	v V
-} {
-	result := make([]struct {
-		k K
-		v V
-	}, 0, len(m))
-	for k, v := range m {
-		result = append(result, struct {
-			k K
-			v V
-		}{k, v})
-	}
-	return result
-}
-
-func CompareFiles(a, b FileTree) float64 {
-	if hex.EncodeToString(a.Root) == hex.EncodeToString(b.Root) {
-		return 1.0
-	}
-
-	if len(a.Leaves) == 0 || len(b.Leaves) == 0 {
-		return 0.0
-	}
-
-	setB := FoldLeft(b.Leaves, make(map[string]bool, len(b.Leaves)),
-		func(acc map[string]bool, leaf string) map[string]bool {
-			acc[leaf] = true
-			return acc
-		})
-
-	matches := FoldLeft(a.Leaves, 0, func(acc int, leaf string) int {
-		if setB[leaf] {
-			return acc + 1
+	opts, err := parseOptions(args[2:])
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
 		}
-		return acc
-	})
-
-	return float64(matches) / float64(len(a.Leaves))
-}
-
-// ============================================================================
-// SMART DUPLICATE GROUPS
-// ============================================================================
-
-func CreateSmartGroups(filesByRoot map[string][]FileTree, partialMatches map[string][]DuplicateMatch, visualMatches map[string][]DuplicateMatch, fileTrees []FileTree) []DuplicateGroup {
-	groups := []DuplicateGroup{}
+	}
 
-	// Exact duplicate groups
-	for _, group := range filesByRoot {
-		if len(group) <= 1 {
-			continue
+	var records []json.RawMessage
+	if err := json.Unmarshal(jsonArg(args[1]), &records); err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Invalid cached signatures: %v", err),
 		}
-
-		groupFiles := Map(group, func(ft FileTree) string { return ft.Path })
-
-		// Calculate savings (keep largest, remove rest)
-		totalSize := FoldLeft(group, int64(0), func(acc int64, ft FileTree) int64 {
-			return acc + ft.Size
-		})
-		savings := totalSize - group[0].Size
-
-		groups = append(groups, DuplicateGroup{
-			Files:      groupFiles,
-			Similarity: 1.0,
-			Size:       totalSize,
-			GroupType:  "exact",
-			Savings:    savings,
-		})
 	}
 
-	// Partial duplicate groups
-	processed := make(map[string]bool)
-	for srcPath, matches := range partialMatches {
-		if processed[srcPath] {
+	// A record this build cannot read is skipped, and its file hashed again
+	var cached []dupes.FileTree
+	for _, raw := range records {
+		var sig dupes.Signature
+		if json.Unmarshal(raw, &sig) != nil {
 			continue
 		}
-
-		// Create group with source and all its matches
-		groupFiles := []string{srcPath}
-		totalSize := int64(0)
-
-		for _, match := range matches {
-			if match.Similarity >= 0.8 && !processed[match.TargetPath] {
-				groupFiles = append(groupFiles, match.TargetPath)
-			}
+		if ft, err := sig.FileTree(); err == nil {
+			cached = append(cached, ft)
 		}
+	}
 
-		if len(groupFiles) > 1 {
-			for _, path := range groupFiles {
-				processed[path] = true
-			}
-
-			groups = append(groups, DuplicateGroup{
-				Files:      groupFiles,
-				Similarity: 0.8,
-				Size:       totalSize,
-				GroupType:  "similar",
-				Savings:    totalSize / 2, // Estimate
-			})
+	result, trees, err := dupes.AnalyzeIncremental(context.Background(), convertFiles(args[0]), cached, opts)
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
 		}
 	}
 
-	// Phase 2: Visual duplicate groups
-	processedVisual := make(map[string]bool)
-	for srcPath, matches := range visualMatches {
-		if processedVisual[srcPath] {
+	chunker := opts.Chunker.Spec()
+	signatures := []dupes.Signature{}
+	for _, ft := range trees {
+		if ft.Stage != dupes.StageFull {
 			continue
 		}
-
-		groupFiles := []string{srcPath}
-		var totalSimilarity float64
-		var matchCount int
-		var totalSize int64
-
-		for _, match := range matches {
-			if match.Similarity >= 0.85 && !processedVisual[match.TargetPath] {
-				groupFiles = append(groupFiles, match.TargetPath)
-				totalSimilarity += match.Similarity
-				matchCount++
+		sig, err := dupes.NewSignature(ft, chunker)
+		if err != nil {
+			return map[string]interface{}{
+				"error": err.Error(),
 			}
 		}
+		signatures = append(signatures, sig)
+	}
 
-		if len(groupFiles) > 1 {
-			for _, path := range groupFiles {
-				processedVisual[path] = true
-			}
-
-			// Calculate average similarity
-			avgSimilarity := 0.9
-			if matchCount > 0 {
-				avgSimilarity = totalSimilarity / float64(matchCount)
-			}
-
-			// Calculate total size (find files in fileTrees)
-			for _, ft := range fileTrees {
-				for _, gf := range groupFiles {
-					if ft.Path == gf {
-						totalSize += ft.Size
-						break
-					}
-				}
-			}
-
-			// Estimate savings (keep one, remove rest)
-			savings := totalSize
-			if len(groupFiles) > 0 {
-				// Assume we keep the first one
-				for _, ft := range fileTrees {
-					if ft.Path == groupFiles[0] {
-						savings = totalSize - ft.Size
-						break
-					}
-				}
-			}
-
-			groups = append(groups, DuplicateGroup{
-				Files:      groupFiles,
-				Similarity: avgSimilarity,
-				Size:       totalSize,
-				GroupType:  "visual",
-				Savings:    savings,
-			})
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"result":     result,
+		"signatures": signatures,
+	})
+	if err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Failed to marshal result: %v", err),
 		}
 	}
 
-	return groups
+	return string(jsonBytes)
 }
 
-// ============================================================================
-// TREE BUILDING
-// ============================================================================
-
-func BuildFileTree(rootPath string, files []FileTree, matches map[string][]DuplicateMatch) FileNode {
-	relFiles := FoldLeft(files, make(map[string]FileTree),
-		func(acc map[string]FileTree, f FileTree) map[string]FileTree {
-			rel, _ := filepath.Rel(rootPath, f.Path)
-			acc[rel] = f
-			return acc
-		})
-
-	root := FileNode{
-		Path:         rootPath,
-		Name:         filepath.Base(rootPath),
-		IsDir:        true,
-		Children:     []FileNode{},
-		RelativePath: "",
+// proveChunk returns the inclusion proof that a chunk hash (hex) is part of a
+// FileTree record, as returned by analyzeIncremental. Records cached without
+// their Tree are rebuilt from the leaves.
+func proveChunk(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return map[string]interface{}{
+			"error": "Expected 2 arguments: fileTree, chunkHash",
+		}
 	}
 
-	for rel, ft := range relFiles {
-		parts := strings.Split(rel, string(filepath.Separator))
-		addToTree(&root, parts, ft, matches, rootPath)
+	var ft dupes.FileTree
+	if err := json.Unmarshal(jsonArg(args[0]), &ft); err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Invalid file tree: %v", err),
+		}
 	}
 
-	return root
-}
-
-func addToTree(node *FileNode, parts []string, ft FileTree, matches map[string][]DuplicateMatch, rootPath string) {
-	if len(parts) == 0 {
-		return
+	proof, err := dupes.ProveChunk(ft, args[1].String())
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}
 	}
 
-	if len(parts) == 1 {
-		fileMatches := matches[ft.Path]
-		bestMatch := FoldLeft(fileMatches, 0.0, func(acc float64, m DuplicateMatch) float64 {
-			if m.Similarity > acc {
-				return m.Similarity
-			}
-			return acc
-		})
-
-		node.Children = append(node.Children, FileNode{
-			Path:         ft.Path,
-			Name:         parts[0],
-			IsDir:        false,
-			Children:     []FileNode{},
-			Matches:      fileMatches,
-			BestMatch:    bestMatch,
-			Size:         ft.Size,
-			RelativePath: ft.Path,
-		})
-		return
+	jsonBytes, err := json.Marshal(proof)
+	if err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Failed to marshal proof: %v", err),
+		}
 	}
-
-	dirName := parts[0]
-	dirNode := findOrCreateDir(node, dirName, rootPath)
-	addToTree(dirNode, parts[1:], ft, matches, rootPath)
+	return string(jsonBytes)
 }
 
-func findOrCreateDir(node *FileNode, dirName string, rootPath string) *FileNode {
-	for i := range node.Children {
-		if node.Children[i].Name == dirName && node.Children[i].IsDir {
-			return &node.Children[i]
+// verifyChunkProof checks a proof from proveChunk against a trusted FileTree
+// record, which supplies the root, hash algorithm and chunk count. It
+// returns {valid: true} or {valid: false, error}.
+func verifyChunkProof(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return map[string]interface{}{
+			"error": "Expected 2 arguments: fileTree, proof",
 		}
 	}
 
-	currentPath := filepath.Join(node.Path, dirName)
-	relativePath, _ := filepath.Rel(rootPath, currentPath)
-
-	node.Children = append(node.Children, FileNode{
-		Name:         dirName,
-		Path:         currentPath,
-		RelativePath: relativePath,
-		IsDir:        true,
-		Children:     []FileNode{},
-	})
-
-	return &node.Children[len(node.Children)-1]
-}
-
-// ============================================================================
-// MAIN DEDUPLICATION
-// ============================================================================
-
-func FindDuplicates(files []JSFile, threshold float64, chunkSize int) DedupResult {
-	startTime := time.Now()
-
-	reportProgress(0, 100, "Starting analysis...", 0)
-
-	// Process all files with progress
-	fileTrees := make([]FileTree, len(files))
-	for i, f := range files {
-		fileTrees[i] = ProcessFile(f, chunkSize, i, len(files))
+	var ft dupes.FileTree
+	if err := json.Unmarshal(jsonArg(args[0]), &ft); err != nil {
+		return map[string]interface{}{
+			"valid": false,
+			"error": fmt.Sprintf("Invalid file tree: %v", err),
+		}
 	}
 
-	reportProgress(30, 100, "Grouping files...", 30)
-
-	// Group by merkle root
-	filesByRoot := GroupBy(fileTrees, func(ft FileTree) string {
-		return hex.EncodeToString(ft.Root)
-	})
-
-	reportProgress(50, 100, "Finding exact duplicates...", 50)
-
-	// Process duplicates
-	exactDups := processExactDuplicates(filesByRoot)
-
-	reportProgress(70, 100, "Finding similar files...", 70)
-
-	partialDups := processPartialDuplicates(fileTrees, exactDups.allMatches, threshold)
-
-	reportProgress(80, 100, "Finding visually similar images...", 80)
-
-	// Phase 2: Visual duplicates (optimized - skip exact matches)
-	// Build set of files already in exact duplicate groups
-	filesInExactGroups := make(map[string]bool)
-	for _, group := range filesByRoot {
-		if len(group) > 1 {
-			for _, ft := range group {
-				filesInExactGroups[ft.Path] = true
-			}
+	var proof dupes.InclusionProof
+	if err := json.Unmarshal(jsonArg(args[1]), &proof); err != nil {
+		return map[string]interface{}{
+			"valid": false,
+			"error": fmt.Sprintf("Invalid proof: %v", err),
 		}
 	}
 
-	// Only check images NOT in exact duplicate groups
-	imagesToCheck := Filter(fileTrees, func(ft FileTree) bool {
-		return ft.IsImage && ft.PHash != 0 && !filesInExactGroups[ft.Path]
-	})
-
-	// Only check videos NOT in exact duplicate groups
-	videosToCheck := Filter(fileTrees, func(ft FileTree) bool {
-		return ft.IsVideo && len(ft.VideoHash) > 0 && !filesInExactGroups[ft.Path]
-	})
-
-	reportProgress(81, 100, fmt.Sprintf("Checking %d images and %d videos for visual similarity...", len(imagesToCheck), len(videosToCheck)), 81)
-
-	// Combine images and videos for visual duplicate detection
-	mediaToCheck := append(imagesToCheck, videosToCheck...)
-	visualDups := findVisualDuplicates(mediaToCheck, 0.85)
-	visualCount := len(visualDups)
-
-	reportProgress(85, 100, "Creating smart groups...", 85)
-
-	// Smart groups (now includes visual matches)
-	smartGroups := CreateSmartGroups(filesByRoot, partialDups.allMatches, visualDups, fileTrees)
-
-	reportProgress(90, 100, "Building file tree...", 90)
-
-	// Combine results (Phase 1 + Phase 2)
-	allMatches := MapMonoid[string, []DuplicateMatch](SliceMonoid[DuplicateMatch]()).Combine(
-		exactDups.allMatches,
-		MapMonoid[string, []DuplicateMatch](SliceMonoid[DuplicateMatch]()).Combine(
-			partialDups.allMatches,
-			visualDups,
-		),
-	)
-
-	rootPath := "/"
-	if len(files) > 0 {
-		rootPath = filepath.Dir(files[0].Path)
+	if err := dupes.VerifyInclusion(ft, proof); err != nil {
+		return map[string]interface{}{
+			"valid": false,
+			"error": err.Error(),
+		}
 	}
-
-	tree := BuildFileTree(rootPath, fileTrees, allMatches)
-
-	totalFiles := len(fileTrees)
-	duplicateFileCount := exactDups.fullDupCount + partialDups.partialDupCount
-	uniqueCount := totalFiles - duplicateFileCount
-
-	processingTime := time.Since(startTime).Seconds()
-
-	reportProgress(100, 100, "Analysis complete!", 100)
-
-	return DedupResult{
-		RootTree:        tree,
-		AllMatches:      allMatches,
-		DuplicateGroups: smartGroups,
-		TotalFiles:      totalFiles,
-		UniqueFiles:     uniqueCount,
-		FullDupCount:    exactDups.fullDupCount,
-		PartialDupCount: partialDups.partialDupCount,
-		VisualDupCount:  visualCount,
-		SpaceSaved:      exactDups.spaceSaved,
-		ProcessingTime:  processingTime,
+	return map[string]interface{}{
+		"valid": true,
 	}
 }
 
-type ExactDupsResult struct {
-	allMatches   map[string][]DuplicateMatch
-	groups       []DuplicateGroup
-	fullDupCount int
-	spaceSaved   int64
-}
-
-func processExactDuplicates(filesByRoot map[string][]FileTree) ExactDupsResult {
-	duplicateGroups := Filter(mapToSlice(filesByRoot),
-		func(pair struct {
-			k string
-			v []FileTree
-		}) bool {
-			return len(pair.v) > 1
-		})
+// diffFiles compares two fully hashed FileTree records (objects or JSON) and
+// returns the byte ranges where they differ, plus their CompareFiles score.
+func diffFiles(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return map[string]interface{}{
+			"error": "Expected 2 arguments: fileTreeA, fileTreeB",
+		}
+	}
 
-	type DupAcc struct {
-		matches        map[string][]DuplicateMatch
-		groups         []DuplicateGroup
-		count          int
-		saved          int64
-		processedPaths map[string]bool
-	}
-
-	result := FoldLeft(duplicateGroups, DupAcc{
-		matches:        make(map[string][]DuplicateMatch),
-		groups:         []DuplicateGroup{},
-		count:          0,
-		saved:          0,
-		processedPaths: make(map[string]bool),
-	}, func(acc DupAcc, pair struct {
-		k string
-		v []FileTree
-	}) DupAcc {
-		group := pair.v
-		groupFiles := Map(group, func(ft FileTree) string { return ft.Path })
-
-		acc.groups = append(acc.groups, DuplicateGroup{
-			Files:      groupFiles,
-			Similarity: 1.0,
-			Size:       group[0].Size,
-		})
+	var a, b dupes.FileTree
+	if err := json.Unmarshal(jsonArg(args[0]), &a); err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Invalid file tree: %v", err),
+		}
+	}
+	if err := json.Unmarshal(jsonArg(args[1]), &b); err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Invalid file tree: %v", err),
+		}
+	}
 
-		for _, src := range group {
-			matches := FoldLeft(group, []DuplicateMatch{},
-				func(macc []DuplicateMatch, tgt FileTree) []DuplicateMatch {
-					if src.Path != tgt.Path {
-						return append(macc, DuplicateMatch{
-							TargetPath: tgt.Path,
-							Similarity: 1.0,
-							SharedSize: src.Size,
-							MatchType:  "exact",
-						})
-					}
-					return macc
-				})
-
-			if len(matches) > 0 {
-				acc.matches[src.Path] = matches
-				acc.count++
-				if !acc.processedPaths[src.Path] {
-					acc.saved += src.Size
-					acc.processedPaths[src.Path] = true
-				}
-			}
+	ranges, err := dupes.MerkleDiff(a, b)
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
 		}
+	}
 
-		return acc
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"ranges":     ranges,
+		"similarity": dupes.CompareFiles(a, b),
 	})
-
-	return ExactDupsResult{
-		allMatches:   result.matches,
-		groups:       result.groups,
-		fullDupCount: result.count,
-		spaceSaved:   result.saved,
+	if err != nil {
+		return map[string]interface{}{
+			"error": fmt.Sprintf("Failed to marshal diff: %v", err),
+		}
 	}
+	return string(jsonBytes)
 }
 
-type PartialDupsResult struct {
-	allMatches      map[string][]DuplicateMatch
-	partialDupCount int
+// jsonArg returns an argument as JSON, stringifying objects passed from JS.
+func jsonArg(v js.Value) []byte {
+	if v.Type() != js.TypeString {
+		v = js.Global().Get("JSON").Call("stringify", v)
+	}
+	return []byte(v.String())
 }
 
-func processPartialDuplicates(fileTrees []FileTree, exactMatches map[string][]DuplicateMatch, threshold float64) PartialDupsResult {
-	chunkIndex := BuildChunkIndex(fileTrees)
+// parseOptions reads the arguments after the file list:
+// threshold, chunkSize, progressCallback and settings.
+func parseOptions(args []js.Value) (dupes.Options, error) {
+	threshold := args[0].Float()
+	chunkSize := args[1].Int()
 
-	type FileWithIndex struct {
-		file  FileTree
-		index int
+	// Set progress callback if provided
+	if len(args) >= 3 && !args[2].IsUndefined() {
+		progressCallback = args[2]
+	}
+
+	// Optional settings: { chunker: "fixed" | "cdc" | "line" | "record", minChunkSize, maxChunkSize,
+	//                     hash, metric, stopFiles, stopLowEntropy, linkage, visualSavings,
+	//                     prefilterKB, partial }
+	spec := dupes.ChunkerSpec{Name: "fixed", Size: chunkSize}
+	hashName := "sha256"
+	metricName := "overlap"
+	stop := dupes.StopChunkOptions{LowEntropy: true}
+	linkageName := ""
+	visualSavingsName := ""
+	prefilterBytes := 0
+	skipPartial := false
+	if len(args) >= 4 && args[3].Type() == js.TypeObject {
+		settings := args[3]
+		if v := settings.Get("prefilterKB"); v.Type() == js.TypeNumber {
+			prefilterBytes = v.Int() * 1024
+		}
+		if v := settings.Get("partial"); v.Type() == js.TypeBoolean {
+			skipPartial = !v.Bool()
+		}
+		if h := settings.Get("hash"); h.Type() == js.TypeString {
+			hashName = h.String()
+		}
+		if m := settings.Get("metric"); m.Type() == js.TypeString {
+			metricName = m.String()
+		}
+		if v := settings.Get("stopFiles"); v.Type() == js.TypeNumber {
+			stop.MaxFiles = v.Int()
+		}
+		if v := settings.Get("stopLowEntropy"); v.Type() == js.TypeBoolean {
+			stop.LowEntropy = v.Bool()
+		}
+		if v := settings.Get("linkage"); v.Type() == js.TypeString {
+			linkageName = v.String()
+		}
+		if v := settings.Get("visualSavings"); v.Type() == js.TypeString {
+			visualSavingsName = v.String()
+		}
+		if c := settings.Get("chunker"); c.Type() == js.TypeString {
+			spec.Name = c.String()
+		}
+		if v := settings.Get("minChunkSize"); v.Type() == js.TypeNumber {
+			spec.MinSize = v.Int()
+		}
+		if v := settings.Get("maxChunkSize"); v.Type() == js.TypeNumber {
+			spec.MaxSize = v.Int()
+		}
 	}
 
-	filesWithIndices := Map(fileTrees, func(ft FileTree) FileWithIndex {
-		idx := findFileIndex(fileTrees, ft)
-		return FileWithIndex{file: ft, index: idx}
-	})
-
-	candidateFiles := Filter(filesWithIndices, func(fwi FileWithIndex) bool {
-		_, hasExact := exactMatches[fwi.file.Path]
-		return !hasExact
-	})
-
-	type PartialAcc struct {
-		matches map[string][]DuplicateMatch
-		count   int
+	chunker, err := dupes.NewChunker(spec)
+	if err != nil {
+		return dupes.Options{}, err
 	}
 
-	result := FoldLeft(candidateFiles, PartialAcc{
-		matches: make(map[string][]DuplicateMatch),
-		count:   0,
-	}, func(acc PartialAcc, fwi FileWithIndex) PartialAcc {
-		src := fwi.file
-		srcIdx := fwi.index
-
-		candidates := FindCandidates(src, chunkIndex, threshold)
-
-		matches := FoldLeft(mapToSlice(candidates), []DuplicateMatch{},
-			func(macc []DuplicateMatch, pair struct {
-				k int
-				v int
-			}) []DuplicateMatch {
-				targetIdx := pair.k
-				if targetIdx == srcIdx {
-					return macc
-				}
+	hash, err := dupes.LookupHashAlgorithm(hashName)
+	if err != nil {
+		return dupes.Options{}, err
+	}
 
-				tgt := fileTrees[targetIdx]
-				srcRoot := hex.EncodeToString(src.Root)
-				tgtRoot := hex.EncodeToString(tgt.Root)
+	metric, err := dupes.LookupSimilarityMetric(metricName)
+	if err != nil {
+		return dupes.Options{}, err
+	}
 
-				if srcRoot == tgtRoot {
-					return macc
-				}
+	linkage, err := dupes.ParseLinkage(linkageName)
+	if err != nil {
+		return dupes.Options{}, err
+	}
 
-				similarity := CompareFiles(src, tgt)
+	visualSavings, err := dupes.ParseVisualSavings(visualSavingsName)
+	if err != nil {
+		return dupes.Options{}, err
+	}
 
-				if similarity >= threshold && similarity < 1.0 {
-					return append(macc, DuplicateMatch{
-						TargetPath: tgt.Path,
-						Similarity: similarity,
-						SharedSize: int64(float64(src.Size) * similarity),
-						MatchType:  "partial",
-					})
-				}
+	return dupes.Options{
+		Threshold: threshold,
+		Chunker:   chunker,
+		Hash:      hash,
+		Metric:    metric,
 
-				return macc
-			})
+		StopChunks: stop,
+		Linkage:    linkage,
 
-		if len(matches) > 0 {
-			acc.matches[src.Path] = matches
-			acc.count++
-		}
+		VisualSavings: visualSavings,
 
-		return acc
-	})
+		PrefilterBytes: prefilterBytes,
+		SkipPartial:    skipPartial,
 
-	return PartialDupsResult{
-		allMatches:      result.matches,
-		partialDupCount: result.count,
-	}
+		Progress: reportProgress,
+		OnError: func(path string, err error) {
+			fmt.Printf("⚠️ Could not read %s: %v\n", path, err)
+		},
+	}, nil
 }
 
-// ============================================================================
-// WASM EXPORTS
-// ============================================================================
-
-func analyzeFiles(this js.Value, args []js.Value) interface{} {
-	if len(args) < 3 {
-		return map[string]interface{}{
-			"error": "Expected 3 arguments: files, threshold, chunkSize",
-		}
-	}
-
-	filesJS := args[0]
-	threshold := args[1].Float()
-	chunkSize := args[2].Int()
-
-	// Set progress callback if provided
-	if len(args) >= 4 && !args[3].IsUndefined() {
-		progressCallback = args[3]
-	}
-
-	// Convert JS files to Go structs
+// convertFiles turns the JS file list into Go structs. A file with a
+// read(offset, length) function is streamed; otherwise its data array is
+// copied in whole.
+func convertFiles(filesJS js.Value) []dupes.JSFile {
 	length := filesJS.Length()
-	files := make([]JSFile, length)
+	files := make([]dupes.JSFile, length)
 
 	for i := 0; i < length; i++ {
 		fileJS := filesJS.Index(i)
 
-		dataJS := fileJS.Get("data")
-		dataLen := dataJS.Get("length").Int()
-		data := make([]byte, dataLen)
-		js.CopyBytesToGo(data, dataJS)
-
 		modTime := int64(0)
 		if !fileJS.Get("modTime").IsUndefined() {
 			modTime = int64(fileJS.Get("modTime").Int())
 		}
 
-		files[i] = JSFile{
+		files[i] = dupes.JSFile{
 			Name:    fileJS.Get("name").String(),
 			Path:    fileJS.Get("path").String(),
 			Size:    int64(fileJS.Get("size").Int()),
-			Data:    data,
 			ModTime: modTime,
 		}
-	}
 
-	// Run deduplication
-	result := FindDuplicates(files, threshold, chunkSize)
-
-	// Convert result to JSON
-	jsonBytes, err := json.Marshal(result)
-	if err != nil {
-		return map[string]interface{}{
-			"error": fmt.Sprintf("Failed to marshal result: %v", err),
+		if read := fileJS.Get("read"); read.Type() == js.TypeFunction {
+			size := files[i].Size
+			files[i].Open = func() (io.ReadCloser, error) {
+				return &blobReader{read: read, size: size}, nil
+			}
+			continue
 		}
+
+		dataJS := fileJS.Get("data")
+		dataLen := dataJS.Get("length").Int()
+		data := make([]byte, dataLen)
+		js.CopyBytesToGo(data, dataJS)
+		files[i].Data = data
 	}
 
-	return string(jsonBytes)
+	return files
 }
 
 func main() {
 	c := make(chan struct{})
 
 	js.Global().Set("analyzeFiles", js.FuncOf(analyzeFiles))
+	js.Global().Set("analyzeIncremental", js.FuncOf(analyzeIncremental))
+	js.Global().Set("proveChunk", js.FuncOf(proveChunk))
+	js.Global().Set("verifyChunkProof", js.FuncOf(verifyChunkProof))
+	js.Global().Set("diffFiles", js.FuncOf(diffFiles))
 
 	fmt.Println("🔍 pure-dupes WASM initialized")
 	fmt.Println("✨ Phase 1 Features: Web Workers, Caching, Smart Groups, Progress")
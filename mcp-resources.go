@@ -0,0 +1,332 @@
+//go:build !js
+
+// mcp-resources.go - Completed scans published as MCP resources
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// Resource URIs:
+//
+//	dupes://scan/{id}/summary                      counts, without groups
+//	dupes://scan/{id}/groups?type=&sort=&offset=&limit=
+//	dupes://scan/{id}/tree/{path}?depth=           one directory of RootTree
+const resourceScheme = "dupes"
+
+// codeResourceNotFound is the MCP error code for an unknown resource URI.
+const codeResourceNotFound = -32002
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+func scanURI(id string, parts ...string) string {
+	u := url.URL{
+		Scheme: resourceScheme,
+		Host:   "scan",
+		Path:   "/" + strings.Join(append([]string{id}, parts...), "/"),
+	}
+	return u.String()
+}
+
+// ============================================================================
+// LIST
+// ============================================================================
+
+func handleResourcesList() map[string]interface{} {
+	resources := []Resource{}
+	for _, entry := range sessions.list() {
+		dir := entry.Request.Directory
+		resources = append(resources,
+			Resource{
+				URI:         scanURI(entry.ID, "summary"),
+				Name:        "Scan summary: " + dir,
+				Description: fmt.Sprintf("Counts for the analysis of %s", dir),
+				MimeType:    "application/json",
+			},
+			Resource{
+				URI:         scanURI(entry.ID, "groups"),
+				Name:        "Duplicate groups: " + dir,
+				Description: fmt.Sprintf("%d duplicate groups, paged with ?offset=&limit=", len(entry.Result.DuplicateGroups)),
+				MimeType:    "application/json",
+			},
+			Resource{
+				URI:         scanURI(entry.ID, "tree"),
+				Name:        "File tree: " + dir,
+				Description: "Directory tree with per-file matches, one level per read",
+				MimeType:    "application/json",
+			},
+		)
+	}
+
+	return map[string]interface{}{"resources": resources}
+}
+
+func handleResourceTemplatesList() map[string]interface{} {
+	return map[string]interface{}{
+		"resourceTemplates": []ResourceTemplate{
+			{
+				URITemplate: "dupes://scan/{scanId}/groups{?type,sort,offset,limit}",
+				Name:        "Duplicate groups",
+				Description: "One page of a scan's duplicate groups. type is exact, similar or visual; sort is savings, size or files",
+				MimeType:    "application/json",
+			},
+			{
+				URITemplate: "dupes://scan/{scanId}/tree/{+path}{?depth}",
+				Name:        "File tree",
+				Description: "A directory of a scan's file tree, expanded depth levels (default 1)",
+				MimeType:    "application/json",
+			},
+		},
+	}
+}
+
+// ============================================================================
+// READ
+// ============================================================================
+
+func handleResourcesRead(paramsRaw json.RawMessage) (interface{}, error) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(paramsRaw, &params); err != nil || params.URI == "" {
+		return nil, &MCPError{Code: codeInvalidParams, Message: "Invalid params: uri required"}
+	}
+
+	payload, err := readResource(params.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{
+				"uri":      params.URI,
+				"mimeType": "application/json",
+				"text":     string(text),
+			},
+		},
+	}, nil
+}
+
+func readResource(uri string) (interface{}, error) {
+	notFound := &MCPError{Code: codeResourceNotFound, Message: fmt.Sprintf("Resource not found: %s", uri)}
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != resourceScheme || u.Host != "scan" {
+		return nil, notFound
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 {
+		return nil, notFound
+	}
+	entry, ok := sessions.find(parts[0])
+	if !ok {
+		return nil, notFound
+	}
+	query := u.Query()
+
+	switch {
+	case parts[1] == "summary" && len(parts) == 2:
+		summary := summarizeResult(entry)
+		summary.Groups = nil
+		return summary, nil
+
+	case parts[1] == "groups" && len(parts) == 2:
+		offset := queryInt(query, "offset", 0)
+		limit := queryInt(query, "limit", 50)
+		page := groupPage(entry, query.Get("type"), query.Get("sort"), offset, limit)
+
+		result := map[string]interface{}{"page": page}
+		if page.NextOffset > 0 {
+			query.Set("offset", strconv.Itoa(page.NextOffset))
+			next := *u
+			next.RawQuery = query.Encode()
+			result["next"] = next.String()
+		}
+		return result, nil
+
+	case parts[1] == "tree":
+		node, ok := findTreeNode(entry.Result.RootTree, parts[2:])
+		if !ok {
+			return nil, notFound
+		}
+		return viewTree(entry.ID, node, queryInt(query, "depth", 1)), nil
+	}
+
+	return nil, notFound
+}
+
+func queryInt(query url.Values, name string, fallback int) int {
+	if n, err := strconv.Atoi(query.Get(name)); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
+
+// ============================================================================
+// TREE VIEW
+// ============================================================================
+
+// TreeView is one node of RootTree with its subtree cut off below a depth.
+// Directories that are not expanded carry a URI to read them separately.
+type TreeView struct {
+	Name      string                 `json:"name"`
+	Path      string                 `json:"path"`
+	IsDir     bool                   `json:"isDir"`
+	Size      int64                  `json:"size"`
+	Files     int                    `json:"files,omitempty"`     // files below a directory
+	DupFiles  int                    `json:"dupFiles,omitempty"`  // of which have matches
+	BestMatch float64                `json:"bestMatch,omitempty"` // files only
+	Matches   []dupes.DuplicateMatch `json:"matches,omitempty"`
+	URI       string                 `json:"uri,omitempty"`
+	Children  []TreeView             `json:"children,omitempty"`
+}
+
+func findTreeNode(root dupes.FileNode, path []string) (dupes.FileNode, bool) {
+	node := root
+	for _, name := range path {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, child := range node.Children {
+			if child.Name == name {
+				node, found = child, true
+				break
+			}
+		}
+		if !found {
+			return dupes.FileNode{}, false
+		}
+	}
+	return node, true
+}
+
+func viewTree(scanID string, node dupes.FileNode, depth int) TreeView {
+	view := TreeView{
+		Name:  node.Name,
+		Path:  node.Path,
+		IsDir: node.IsDir,
+		Size:  node.Size,
+	}
+
+	if !node.IsDir {
+		view.BestMatch = node.BestMatch
+		view.Matches = node.Matches
+		return view
+	}
+
+	view.Size, view.Files, view.DupFiles = treeTotals(node)
+	if node.RelativePath != "" {
+		view.URI = scanURI(scanID, append([]string{"tree"}, strings.Split(filepath.ToSlash(node.RelativePath), "/")...)...)
+	} else {
+		view.URI = scanURI(scanID, "tree")
+	}
+
+	if depth > 0 {
+		children := append([]dupes.FileNode{}, node.Children...)
+		sort.Slice(children, func(i, j int) bool {
+			if children[i].IsDir != children[j].IsDir {
+				return children[i].IsDir
+			}
+			return children[i].Name < children[j].Name
+		})
+		for _, child := range children {
+			view.Children = append(view.Children, viewTree(scanID, child, depth-1))
+		}
+	}
+	return view
+}
+
+func treeTotals(node dupes.FileNode) (size int64, files, dupFiles int) {
+	if !node.IsDir {
+		if len(node.Matches) > 0 {
+			dupFiles = 1
+		}
+		return node.Size, 1, dupFiles
+	}
+	for _, child := range node.Children {
+		s, f, d := treeTotals(child)
+		size += s
+		files += f
+		dupFiles += d
+	}
+	return size, files, dupFiles
+}
+
+// ============================================================================
+// SUBSCRIPTIONS
+// ============================================================================
+
+func (s *rpcServer) subscribe(paramsRaw json.RawMessage, on bool) (interface{}, error) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(paramsRaw, &params); err != nil || params.URI == "" {
+		return nil, &MCPError{Code: codeInvalidParams, Message: "Invalid params: uri required"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if on {
+		s.subscriptions[params.URI] = true
+	} else {
+		delete(s.subscriptions, params.URI)
+	}
+	return map[string]interface{}{}, nil
+}
+
+// scanUpdated tells the client about a stored analysis: list_changed for a
+// scan ID it has not seen, resources/updated for subscribed URIs of the scan.
+func (s *rpcServer) scanUpdated(entry *scanEntry) {
+	prefix := scanURI(entry.ID)
+
+	s.mu.Lock()
+	isNew := !s.scans[entry.ID]
+	s.scans[entry.ID] = true
+	updated := []string{}
+	for uri := range s.subscriptions {
+		if uri == prefix || strings.HasPrefix(uri, prefix+"/") {
+			updated = append(updated, uri)
+		}
+	}
+	s.mu.Unlock()
+
+	if isNew {
+		s.send(MCPNotification{JSONRPC: "2.0", Method: "notifications/resources/list_changed"})
+	}
+	sort.Strings(updated)
+	for _, uri := range updated {
+		s.send(MCPNotification{
+			JSONRPC: "2.0",
+			Method:  "notifications/resources/updated",
+			Params:  map[string]interface{}{"uri": uri},
+		})
+	}
+}
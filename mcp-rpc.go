@@ -0,0 +1,381 @@
+//go:build !js
+
+// mcp-rpc.go - JSON-RPC 2.0 transport for the MCP server
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+var nullID = json.RawMessage("null")
+
+type MCPNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// toolContext carries per-call state into tool handlers: cancellation from
+// notifications/cancelled and, when the client asked for it, progress.
+type toolContext struct {
+	ctx      context.Context
+	progress dupes.ProgressFunc
+}
+
+// rpcServer reads newline-delimited JSON-RPC messages and answers them.
+// Requests run concurrently so a notifications/cancelled can reach a long
+// tools/call while it is still running.
+type rpcServer struct {
+	in *bufio.Reader
+
+	outMu sync.Mutex
+	out   *json.Encoder
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc // request id -> cancel
+
+	subscriptions map[string]bool // resource URIs
+	scans         map[string]bool // scan IDs the client has been told about
+
+	wg sync.WaitGroup
+}
+
+func newRPCServer(in io.Reader, out io.Writer) *rpcServer {
+	return &rpcServer{
+		in:            bufio.NewReader(in),
+		out:           json.NewEncoder(out),
+		inflight:      make(map[string]context.CancelFunc),
+		subscriptions: make(map[string]bool),
+		scans:         make(map[string]bool),
+	}
+}
+
+func (s *rpcServer) serve() {
+	for {
+		line, err := s.in.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			s.dispatch(trimmed)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading input: %v", err)
+			}
+			break
+		}
+	}
+
+	s.wg.Wait()
+}
+
+// pendingCall is a decoded request ready to run.
+type pendingCall struct {
+	req MCPRequest
+	ctx context.Context
+}
+
+func (s *rpcServer) dispatch(raw []byte) {
+	if raw[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(raw, &batch); err != nil {
+			s.send(errorResponse(nullID, codeParseError, "Parse error"))
+			return
+		}
+		if len(batch) == 0 {
+			s.send(errorResponse(nullID, codeInvalidRequest, "Invalid Request: empty batch"))
+			return
+		}
+
+		// Decode and register the whole batch before running any of it
+		calls := make([]pendingCall, 0, len(batch))
+		responses := []*MCPResponse{}
+		for _, item := range batch {
+			call, errResp := s.prepare(item)
+			if errResp != nil {
+				responses = append(responses, errResp)
+				continue
+			}
+			calls = append(calls, call)
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			for _, call := range calls {
+				wg.Add(1)
+				go func(call pendingCall) {
+					defer wg.Done()
+					if resp := s.handle(call); resp != nil {
+						mu.Lock()
+						responses = append(responses, resp)
+						mu.Unlock()
+					}
+				}(call)
+			}
+			wg.Wait()
+
+			// A batch of notifications gets no reply at all
+			if len(responses) > 0 {
+				s.send(responses)
+			}
+		}()
+		return
+	}
+
+	call, errResp := s.prepare(raw)
+	if errResp != nil {
+		s.send(errResp)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if resp := s.handle(call); resp != nil {
+			s.send(resp)
+		}
+	}()
+}
+
+// prepare decodes one message and registers requests as in flight.
+func (s *rpcServer) prepare(raw []byte) (pendingCall, *MCPResponse) {
+	if !json.Valid(raw) {
+		return pendingCall{}, errorResponse(nullID, codeParseError, "Parse error")
+	}
+
+	var req MCPRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return pendingCall{}, errorResponse(nullID, codeInvalidRequest, "Invalid Request")
+	}
+
+	id := req.ID
+	if isNotification(req) {
+		id = nullID
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if isNotification(req) && req.Method != "" {
+			return pendingCall{}, nil
+		}
+		return pendingCall{}, errorResponse(id, codeInvalidRequest, "Invalid Request")
+	}
+
+	// notifications/cancelled is acted on right away rather than queued
+	// behind the call it cancels
+	if req.Method == "notifications/cancelled" {
+		s.cancel(req.Params)
+		return pendingCall{req: req, ctx: context.Background()}, nil
+	}
+
+	ctx := context.Background()
+	if !isNotification(req) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		s.mu.Lock()
+		s.inflight[idKey(req.ID)] = cancel
+		s.mu.Unlock()
+	}
+
+	return pendingCall{req: req, ctx: ctx}, nil
+}
+
+// handle runs one message. It returns nil when no reply should be sent:
+// for notifications and for requests the client cancelled.
+func (s *rpcServer) handle(call pendingCall) *MCPResponse {
+	req := call.req
+
+	if isNotification(req) {
+		switch req.Method {
+		case "notifications/initialized":
+			log.Println("Client initialized")
+		case "notifications/cancelled":
+			// already handled in prepare
+		default:
+			log.Printf("Ignoring notification: %s", req.Method)
+		}
+		return nil
+	}
+
+	defer func() {
+		s.mu.Lock()
+		if cancel, ok := s.inflight[idKey(req.ID)]; ok {
+			cancel()
+			delete(s.inflight, idKey(req.ID))
+		}
+		s.mu.Unlock()
+	}()
+
+	log.Printf("Received request: %s", req.Method)
+
+	resp := &MCPResponse{JSONRPC: "2.0", ID: req.ID}
+
+	switch req.Method {
+	case "initialize":
+		resp.Result = handleInitialize()
+
+	case "ping":
+		resp.Result = map[string]interface{}{}
+
+	case "tools/list":
+		resp.Result = handleToolsList()
+
+	case "resources/list":
+		resp.Result = handleResourcesList()
+
+	case "resources/templates/list":
+		resp.Result = handleResourceTemplatesList()
+
+	case "resources/read":
+		resp.Result, resp.Error = protocolResult(handleResourcesRead(req.Params))
+
+	case "resources/subscribe", "resources/unsubscribe":
+		resp.Result, resp.Error = protocolResult(s.subscribe(req.Params, req.Method == "resources/subscribe"))
+
+	case "tools/call":
+		tc := toolContext{ctx: call.ctx, progress: s.progressFor(req.Params)}
+		result, err := handleToolCall(tc, req.Params)
+
+		var rpcErr *MCPError
+		switch {
+		case call.ctx.Err() != nil:
+			log.Printf("Request %s cancelled", idKey(req.ID))
+			return nil
+		case errors.As(err, &rpcErr):
+			resp.Error = rpcErr
+		case err != nil:
+			// Tool failures are results the model can read, not protocol errors
+			resp.Result = map[string]interface{}{
+				"content": []map[string]interface{}{textContent(err.Error())},
+				"isError": true,
+			}
+		default:
+			resp.Result = result
+		}
+
+	default:
+		resp.Error = &MCPError{
+			Code:    codeMethodNotFound,
+			Message: fmt.Sprintf("Method not found: %s", req.Method),
+		}
+	}
+
+	return resp
+}
+
+// cancel aborts the in-flight request named by notifications/cancelled.
+func (s *rpcServer) cancel(paramsRaw json.RawMessage) {
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+		Reason    string          `json:"reason"`
+	}
+	if err := json.Unmarshal(paramsRaw, &params); err != nil || len(params.RequestID) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.inflight[idKey(params.RequestID)]
+	s.mu.Unlock()
+
+	if ok {
+		log.Printf("Cancelling request %s: %s", idKey(params.RequestID), params.Reason)
+		cancel()
+	}
+}
+
+// progressFor returns a ProgressFunc that sends notifications/progress when
+// the request carried a progress token, and nil otherwise.
+func (s *rpcServer) progressFor(paramsRaw json.RawMessage) dupes.ProgressFunc {
+	var params struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(paramsRaw, &params); err != nil || len(params.Meta.ProgressToken) == 0 {
+		return nil
+	}
+	token := params.Meta.ProgressToken
+
+	var mu sync.Mutex
+	last := -1.0
+	return func(current, total int, message string, percent float64) {
+		// Progress must increase with every notification
+		mu.Lock()
+		if percent <= last {
+			mu.Unlock()
+			return
+		}
+		last = percent
+		mu.Unlock()
+
+		s.send(MCPNotification{
+			JSONRPC: "2.0",
+			Method:  "notifications/progress",
+			Params: map[string]interface{}{
+				"progressToken": token,
+				"progress":      percent,
+				"total":         100,
+				"message":       message,
+			},
+		})
+	}
+}
+
+func (s *rpcServer) send(v interface{}) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	if err := s.out.Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func isNotification(req MCPRequest) bool {
+	return len(req.ID) == 0
+}
+
+func idKey(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// protocolResult turns a handler's error into a JSON-RPC error.
+func protocolResult(result interface{}, err error) (interface{}, *MCPError) {
+	if err == nil {
+		return result, nil
+	}
+	var rpcErr *MCPError
+	if errors.As(err, &rpcErr) {
+		return nil, rpcErr
+	}
+	return nil, &MCPError{Code: codeInternalError, Message: err.Error()}
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *MCPResponse {
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &MCPError{Code: code, Message: message},
+	}
+}
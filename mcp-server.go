@@ -1,27 +1,37 @@
+//go:build !js
+
 // mcp-server.go - MCP Server for pure-dupes
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+	"github.com/vinodhalaharvi/pure-dupes/hashdb"
 )
 
 // MCP Protocol types
 type MCPRequest struct {
 	JSONRPC string          `json:"jsonrpc"`
-	ID      interface{}     `json:"id"`
+	ID      json.RawMessage `json:"id,omitempty"` // absent for notifications
 	Method  string          `json:"method"`
 	Params  json.RawMessage `json:"params,omitempty"`
 }
 
 type MCPResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	ID      interface{} `json:"id"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *MCPError   `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *MCPError       `json:"error,omitempty"`
 }
 
 type MCPError struct {
@@ -29,67 +39,42 @@ type MCPError struct {
 	Message string `json:"message"`
 }
 
+func (e *MCPError) Error() string { return e.Message }
+
 type Tool struct {
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	InputSchema map[string]interface{} `json:"inputSchema"`
 }
 
+// Analyses kept between tool calls
+var sessions *sessionStore
+
 // Main MCP Server
 func main() {
+	cacheDir := flag.String("cache-dir", os.Getenv("PURE_DUPES_CACHE_DIR"), "directory for persisting analyses between runs (optional)")
+	dbPath := flag.String("db", os.Getenv("PURE_DUPES_DB"), "hash database so re-analysis only rehashes changed files (optional)")
+	flag.Parse()
+
 	log.SetOutput(os.Stderr)
 	log.Println("🔍 pure-dupes MCP Server starting...")
 
-	// Read from stdin, write to stdout
-	decoder := json.NewDecoder(os.Stdin)
-	encoder := json.NewEncoder(os.Stdout)
-
-	for {
-		var req MCPRequest
-		if err := decoder.Decode(&req); err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Printf("Error decoding request: %v", err)
-			continue
-		}
-
-		log.Printf("Received request: %s", req.Method)
-
-		var resp MCPResponse
-		resp.JSONRPC = "2.0"
-		resp.ID = req.ID
-
-		switch req.Method {
-		case "initialize":
-			resp.Result = handleInitialize()
-
-		case "tools/list":
-			resp.Result = handleToolsList()
-
-		case "tools/call":
-			result, err := handleToolCall(req.Params)
-			if err != nil {
-				resp.Error = &MCPError{
-					Code:    -32603,
-					Message: err.Error(),
-				}
-			} else {
-				resp.Result = result
-			}
-
-		default:
-			resp.Error = &MCPError{
-				Code:    -32601,
-				Message: fmt.Sprintf("Method not found: %s", req.Method),
-			}
-		}
-
-		if err := encoder.Encode(resp); err != nil {
-			log.Printf("Error encoding response: %v", err)
+	sessions = newSessionStore(*cacheDir)
+	if *dbPath != "" {
+		db, err := hashdb.Open(*dbPath, hashdb.Options{})
+		if err != nil {
+			log.Printf("Disabling hash database: %v", err)
+		} else {
+			defer db.Close()
+			sessions.hashes = db
 		}
 	}
 
+	// Newline-delimited JSON-RPC on stdin/stdout
+	server := newRPCServer(os.Stdin, os.Stdout)
+	sessions.onUpdate = server.scanUpdated
+	server.serve()
+
 	log.Println("MCP Server shutting down")
 }
 
@@ -102,6 +87,10 @@ func handleInitialize() map[string]interface{} {
 		},
 		"capabilities": map[string]interface{}{
 			"tools": map[string]interface{}{},
+			"resources": map[string]interface{}{
+				"subscribe":   true,
+				"listChanged": true,
+			},
 		},
 	}
 }
@@ -111,55 +100,152 @@ func handleToolsList() map[string]interface{} {
 		{
 			Name:        "analyze_duplicates",
 			Description: "Analyze a directory for duplicate files using Merkle tree-based content hashing. Finds exact and partial duplicates.",
+			InputSchema: map[string]interface{}{
+				"type":       "object",
+				"properties": analysisProperties(),
+				"required":   []string{"directory"},
+			},
+		},
+		{
+			Name:        "get_duplicate_groups",
+			Description: "Get smart duplicate groups showing files that can be safely removed. Reuses the previous analysis of the directory with the same settings unless files changed since.",
 			InputSchema: map[string]interface{}{
 				"type": "object",
-				"properties": map[string]interface{}{
-					"directory": map[string]interface{}{
+				"properties": withProperties(analysisProperties(), map[string]interface{}{
+					"group_type": map[string]interface{}{
 						"type":        "string",
-						"description": "Path to directory to analyze",
+						"description": "Only return groups of this type",
+						"enum":        []string{"exact", "similar", "visual"},
 					},
-					"threshold": map[string]interface{}{
-						"type":        "number",
-						"description": "Similarity threshold (0.0-1.0) for partial matches. Default: 0.8",
-						"default":     0.8,
+					"sort": map[string]interface{}{
+						"type":        "string",
+						"description": "Sort order: savings (largest first), size or files. Default: savings",
+						"enum":        []string{"savings", "size", "files"},
+						"default":     "savings",
 					},
-					"max_depth": map[string]interface{}{
+					"offset": map[string]interface{}{
 						"type":        "integer",
-						"description": "Maximum directory depth to scan. Default: 10",
-						"default":     10,
+						"description": "Number of groups to skip. Default: 0",
+						"default":     0,
 					},
-				},
+					"limit": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum number of groups to return. Default: 50",
+						"default":     50,
+					},
+				}),
 				"required": []string{"directory"},
 			},
 		},
 		{
-			Name:        "get_duplicate_groups",
-			Description: "Get smart duplicate groups showing files that can be safely removed",
+			Name:        "check_file_hash",
+			Description: "Hash a file the same way the analyzer does (SHA-256, Merkle root, chunk leaves, perceptual hash for images) and list known duplicates from the last analysis",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
-					"directory": map[string]interface{}{
+					"file_path": map[string]interface{}{
 						"type":        "string",
-						"description": "Path to directory previously analyzed",
+						"description": "Path to file to hash",
+					},
+					"chunk_size": map[string]interface{}{
+						"type":        "integer",
+						"description": "Chunk size in bytes. Default: the last analysis's chunk size, or 4096",
+					},
+					"include_leaves": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Include every chunk leaf hash. Default: false",
+						"default":     false,
+					},
+					"find_duplicates": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Look the file up in the last analysis. Default: true",
+						"default":     true,
 					},
 				},
-				"required": []string{"directory"},
+				"required": []string{"file_path"},
 			},
 		},
 		{
-			Name:        "check_file_hash",
-			Description: "Get content hash for a specific file",
+			Name:        "compare_files",
+			Description: "Compare two files chunk by chunk: similarity in both directions and the byte ranges they share",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"file_a": map[string]interface{}{
+						"type":        "string",
+						"description": "First file",
+					},
+					"file_b": map[string]interface{}{
+						"type":        "string",
+						"description": "Second file",
+					},
+					"chunker": map[string]interface{}{
+						"type":        "string",
+						"description": "Chunker: fixed, cdc (survives insertions), line or record. Default: fixed",
+						"enum":        []string{"fixed", "cdc", "line", "record"},
+						"default":     "fixed",
+					},
+					"chunk_size": map[string]interface{}{
+						"type":        "integer",
+						"description": "Chunk size in bytes (average size for cdc). Default: 4096",
+						"default":     4096,
+					},
+					"max_ranges": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum number of matching ranges to list. Default: 100",
+						"default":     100,
+					},
+				},
+				"required": []string{"file_a", "file_b"},
+			},
+		},
+		{
+			Name:        "find_similar_to",
+			Description: "Find files in the last analyzed directory that are exact, partial or visual matches of a given file",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
 					"file_path": map[string]interface{}{
 						"type":        "string",
-						"description": "Path to file to hash",
+						"description": "File to search for; it does not need to be inside the analyzed directory",
+					},
+					"threshold": map[string]interface{}{
+						"type":        "number",
+						"description": "Minimum share of chunks in common (0.0-1.0). Default: the analysis threshold",
+					},
+					"limit": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum number of matches. Default: 20",
+						"default":     20,
 					},
 				},
 				"required": []string{"file_path"},
 			},
 		},
+		{
+			Name:        "plan_cleanup",
+			Description: "Propose which file to keep and which to remove in each duplicate group. A file kept by any group is never removed by another. Read-only: nothing on disk is changed.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": withProperties(analysisProperties(), map[string]interface{}{
+					"keep": map[string]interface{}{
+						"type":        "string",
+						"description": "Which file of each group to keep. Default: oldest",
+						"enum":        []string{"oldest", "newest", "shortest_path", "largest", "first"},
+						"default":     "oldest",
+					},
+					"group_types": map[string]interface{}{
+						"type":        "array",
+						"description": "Group types to include. Default: [\"exact\"]",
+						"items": map[string]interface{}{
+							"type": "string",
+							"enum": []string{"exact", "similar", "visual"},
+						},
+					},
+				}),
+				"required": []string{"directory"},
+			},
+		},
 	}
 
 	return map[string]interface{}{
@@ -167,100 +253,410 @@ func handleToolsList() map[string]interface{} {
 	}
 }
 
-func handleToolCall(paramsRaw json.RawMessage) (interface{}, error) {
+// analysisProperties describes the arguments that select an analysis.
+func analysisProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"directory": map[string]interface{}{
+			"type":        "string",
+			"description": "Path to directory to analyze",
+		},
+		"threshold": map[string]interface{}{
+			"type":        "number",
+			"description": "Similarity threshold (0.0-1.0) for partial matches. Default: 0.8",
+			"default":     0.8,
+		},
+		"max_depth": map[string]interface{}{
+			"type":        "integer",
+			"description": "Maximum directory depth to scan. Default: 10",
+			"default":     10,
+		},
+		"chunk_size": map[string]interface{}{
+			"type":        "integer",
+			"description": "Chunk size in bytes for Merkle leaves. Default: 4096",
+			"default":     4096,
+		},
+		"metric": map[string]interface{}{
+			"type":        "string",
+			"description": "Score partial matches are thresholded on: overlap (any shared chunk), jaccard (repeats counted), containment (either file inside the other, repeats counted), run (longest shared run). Default: overlap",
+			"enum":        dupes.SimilarityMetricNames(),
+			"default":     "overlap",
+		},
+	}
+}
+
+func withProperties(base, extra map[string]interface{}) map[string]interface{} {
+	for k, v := range extra {
+		base[k] = v
+	}
+	return base
+}
+
+func handleToolCall(tc toolContext, paramsRaw json.RawMessage) (interface{}, error) {
 	var params struct {
 		Name      string                 `json:"name"`
 		Arguments map[string]interface{} `json:"arguments"`
 	}
 
 	if err := json.Unmarshal(paramsRaw, &params); err != nil {
-		return nil, fmt.Errorf("invalid params: %v", err)
+		return nil, &MCPError{Code: codeInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
 	}
 
 	log.Printf("Tool call: %s", params.Name)
 
 	switch params.Name {
 	case "analyze_duplicates":
-		return analyzeDirectoryTool(params.Arguments)
+		return analyzeDirectoryTool(tc, params.Arguments)
 
 	case "get_duplicate_groups":
-		return getDuplicateGroupsTool(params.Arguments)
+		return getDuplicateGroupsTool(tc, params.Arguments)
 
 	case "check_file_hash":
 		return checkFileHashTool(params.Arguments)
 
+	case "compare_files":
+		return compareFilesTool(params.Arguments)
+
+	case "find_similar_to":
+		return findSimilarToTool(params.Arguments)
+
+	case "plan_cleanup":
+		return planCleanupTool(tc, params.Arguments)
+
 	default:
-		return nil, fmt.Errorf("unknown tool: %s", params.Name)
+		return nil, &MCPError{Code: codeInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", params.Name)}
 	}
 }
 
-func analyzeDirectoryTool(args map[string]interface{}) (interface{}, error) {
-	directory, ok := args["directory"].(string)
-	if !ok {
-		return nil, fmt.Errorf("directory parameter required")
+func analyzeDirectoryTool(tc toolContext, args map[string]interface{}) (interface{}, error) {
+	req, err := parseAnalysisRequest(args)
+	if err != nil {
+		return nil, err
 	}
 
-	threshold := 0.8
-	if t, ok := args["threshold"].(float64); ok {
-		threshold = t
-	}
+	log.Printf("Analyzing directory: %s (threshold: %.2f, depth: %d, chunk: %d)", req.Directory, req.Threshold, req.MaxDepth, req.ChunkSize)
 
-	maxDepth := 10
-	if d, ok := args["max_depth"].(float64); ok {
-		maxDepth = int(d)
+	entry, err := sessions.analyze(tc, req)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("Analyzing directory: %s (threshold: %.2f, depth: %d)", directory, threshold, maxDepth)
+	summary := summarizeResult(entry)
+
+	structured, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO: Implement actual directory analysis
-	// For now, return mock data
 	return map[string]interface{}{
 		"content": []map[string]interface{}{
-			{
-				"type": "text",
-				"text": fmt.Sprintf(
-					"Analyzing directory: %s\n\nConfiguration:\n- Threshold: %.0f%%\n- Max Depth: %d\n\nThis would scan the directory using Merkle trees to find duplicates.\n\nNote: Full implementation requires file system access.",
-					directory, threshold*100, maxDepth,
-				),
-			},
+			textContent(summaryText(summary)),
+			textContent(string(structured)),
 		},
+		"structuredContent": summary,
 	}, nil
 }
 
-func getDuplicateGroupsTool(args map[string]interface{}) (interface{}, error) {
-	directory, ok := args["directory"].(string)
-	if !ok {
-		return nil, fmt.Errorf("directory parameter required")
+// AnalysisSummary is the structured payload returned by analyze_duplicates.
+type AnalysisSummary struct {
+	Directory       string                 `json:"directory"`
+	ScanID          string                 `json:"scanId"`
+	TotalFiles      int                    `json:"totalFiles"`
+	UniqueFiles     int                    `json:"uniqueFiles"`
+	FullDupCount    int                    `json:"fullDupCount"`
+	PartialDupCount int                    `json:"partialDupCount"`
+	VisualDupCount  int                    `json:"visualDupCount"`
+	SpaceSaved      int64                  `json:"spaceSaved"`
+	Savings         dupes.SavingsBreakdown `json:"savings"`
+	ProcessingTime  float64                `json:"processingTime"`
+	Groups          []dupes.DuplicateGroup `json:"groups,omitempty"`
+}
+
+func summarizeResult(entry *scanEntry) AnalysisSummary {
+	result := entry.Result
+	return AnalysisSummary{
+		Directory:       entry.Request.Directory,
+		ScanID:          entry.ID,
+		TotalFiles:      result.TotalFiles,
+		UniqueFiles:     result.UniqueFiles,
+		FullDupCount:    result.FullDupCount,
+		PartialDupCount: result.PartialDupCount,
+		VisualDupCount:  result.VisualDupCount,
+		SpaceSaved:      result.SpaceSaved,
+		Savings:         result.Savings,
+		ProcessingTime:  result.ProcessingTime,
+		Groups:          result.DuplicateGroups,
+	}
+}
+
+func summaryText(s AnalysisSummary) string {
+	counts := map[string]int{}
+	for _, g := range s.Groups {
+		counts[g.GroupType]++
+	}
+
+	return fmt.Sprintf(
+		"Analyzed %s in %.2fs\n\n- Files scanned: %d\n- Unique files: %d\n- Exact duplicates: %d\n- Partial duplicates: %d\n- Visual duplicates: %d\n- Space that can be saved: %d bytes (plus %d by chunk-level dedup of similar files, %d from visual matches)\n- Groups: %d exact, %d similar, %d visual\n\nBrowse with resources %s and %s",
+		s.Directory, s.ProcessingTime,
+		s.TotalFiles, s.UniqueFiles, s.FullDupCount, s.PartialDupCount, s.VisualDupCount, s.SpaceSaved, s.Savings.Similar, s.Savings.Visual,
+		counts["exact"], counts["similar"], counts["visual"],
+		scanURI(s.ScanID, "groups"), scanURI(s.ScanID, "tree"),
+	)
+}
+
+func textContent(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "text",
+		"text": text,
+	}
+}
+
+// GroupPage is one page of duplicate groups from a cached analysis.
+type GroupPage struct {
+	Directory  string                 `json:"directory"`
+	ScanID     string                 `json:"scanId"`
+	AnalyzedAt string                 `json:"analyzedAt"`
+	Total      int                    `json:"total"`
+	Offset     int                    `json:"offset"`
+	Groups     []dupes.DuplicateGroup `json:"groups"`
+	NextOffset int                    `json:"nextOffset,omitempty"`
+}
+
+func getDuplicateGroupsTool(tc toolContext, args map[string]interface{}) (interface{}, error) {
+	req, err := parseAnalysisRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	groupType, _ := args["group_type"].(string)
+	sortBy, _ := args["sort"].(string)
+
+	offset := 0
+	if o, ok := args["offset"].(float64); ok && o > 0 {
+		offset = int(o)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+
+	entry, err := sessions.analyze(tc, req)
+	if err != nil {
+		return nil, err
+	}
+
+	page := groupPage(entry, groupType, sortBy, offset, limit)
+
+	structured, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Duplicate groups for %s: showing %d of %d (offset %d)", req.Directory, len(page.Groups), page.Total, offset)
+	for _, g := range page.Groups {
+		text += fmt.Sprintf("\n\n[%s] %.0f%% similar, saves %d bytes", g.GroupType, g.Similarity*100, g.Savings)
+		for _, f := range g.Files {
+			text += "\n  " + f
+		}
 	}
 
 	return map[string]interface{}{
 		"content": []map[string]interface{}{
-			{
-				"type": "text",
-				"text": fmt.Sprintf(
-					"Duplicate groups for: %s\n\nThis would return smart groups of duplicate files with:\n- Exact matches\n- Similar files\n- Potential space savings\n- Recommended files to keep/remove",
-					directory,
-				),
-			},
+			textContent(text),
+			textContent(string(structured)),
 		},
+		"structuredContent": page,
 	}, nil
 }
 
+// groupPage filters and sorts an analysis's groups and cuts out one page.
+func groupPage(entry *scanEntry, groupType, sortBy string, offset, limit int) GroupPage {
+	groups := dupes.Filter(entry.Result.DuplicateGroups, func(g dupes.DuplicateGroup) bool {
+		return groupType == "" || g.GroupType == groupType
+	})
+	sortGroups(groups, sortBy)
+
+	page := GroupPage{
+		Directory:  entry.Request.Directory,
+		ScanID:     entry.ID,
+		AnalyzedAt: entry.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		Total:      len(groups),
+		Offset:     offset,
+		Groups:     []dupes.DuplicateGroup{},
+	}
+	if offset < len(groups) {
+		end := offset + limit
+		if end < len(groups) {
+			page.NextOffset = end
+		} else {
+			end = len(groups)
+		}
+		page.Groups = groups[offset:end]
+	}
+	return page
+}
+
+func sortGroups(groups []dupes.DuplicateGroup, by string) {
+	key := func(g dupes.DuplicateGroup) int64 { return g.Savings }
+	switch by {
+	case "size":
+		key = func(g dupes.DuplicateGroup) int64 { return g.Size }
+	case "files":
+		key = func(g dupes.DuplicateGroup) int64 { return int64(len(g.Files)) }
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return key(groups[i]) > key(groups[j])
+	})
+}
+
+// FileHashReport is the structured payload returned by check_file_hash.
+type FileHashReport struct {
+	Path            string                 `json:"path"`
+	Size            int64                  `json:"size"`
+	SHA256          string                 `json:"sha256"`
+	HashAlgorithm   string                 `json:"hashAlgorithm"`
+	MerkleRoot      string                 `json:"merkleRoot"`
+	ChunkSize       int                    `json:"chunkSize"`
+	ChunkCount      int                    `json:"chunkCount"`
+	Leaves          []string               `json:"leaves,omitempty"`
+	IsImage         bool                   `json:"isImage"`
+	PHash           string                 `json:"pHash,omitempty"`
+	AnalyzedIn      string                 `json:"analyzedIn,omitempty"`
+	KnownDuplicates []dupes.DuplicateMatch `json:"knownDuplicates,omitempty"`
+}
+
 func checkFileHashTool(args map[string]interface{}) (interface{}, error) {
 	filePath, ok := args["file_path"].(string)
 	if !ok {
 		return nil, fmt.Errorf("file_path parameter required")
 	}
 
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	last, haveLast := sessions.latest()
+
+	spec := dupes.ChunkerSpec{Name: "fixed", Size: 4096}
+	if haveLast {
+		spec = last.Result.Chunker
+	}
+	if c, ok := args["chunk_size"].(float64); ok {
+		spec = dupes.ChunkerSpec{Name: "fixed", Size: int(c)}
+	}
+
+	includeLeaves, _ := args["include_leaves"].(bool)
+	findDuplicates := true
+	if f, ok := args["find_duplicates"].(bool); ok {
+		findDuplicates = f
+	}
+
+	files, err := dupes.CollectFiles([]string{abs}, dupes.WalkOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("%s is not a regular file", abs)
+	}
+	file := files[0]
+
+	ft, err := hashFile(file, spec, "sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	whole, err := sha256File(file)
+	if err != nil {
+		return nil, err
+	}
+	report := FileHashReport{
+		Path:          abs,
+		Size:          file.Size,
+		SHA256:        whole,
+		HashAlgorithm: ft.HashAlg,
+		MerkleRoot:    hex.EncodeToString(ft.Root),
+		ChunkSize:     spec.Size,
+		ChunkCount:    ft.ChunkCount,
+		IsImage:       ft.IsImage,
+	}
+	if includeLeaves {
+		report.Leaves = ft.Leaves
+	}
+	if ft.IsImage && ft.PHash != 0 {
+		report.PHash = fmt.Sprintf("%016x", ft.PHash)
+	}
+
+	if findDuplicates && haveLast {
+		// Roots only compare under the analysis's own chunker and hash
+		lookup := ft
+		if spec != last.Result.Chunker || ft.HashAlg != last.Result.HashAlgorithm {
+			lookup, err = hashFile(file, last.Result.Chunker, last.Result.HashAlgorithm)
+			if err != nil {
+				return nil, err
+			}
+		}
+		report.AnalyzedIn = last.Request.Directory
+		report.KnownDuplicates = similarTo(lookup, last, last.Request.Threshold)
+	}
+
+	structured, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf(
+		"File: %s\n\n- Size: %d bytes\n- SHA-256: %s\n- Merkle root (%s, %d-byte chunks): %s\n- Chunk count: %d",
+		report.Path, report.Size, report.SHA256, report.HashAlgorithm, report.ChunkSize, report.MerkleRoot, report.ChunkCount,
+	)
+	if report.PHash != "" {
+		text += "\n- Perceptual hash: " + report.PHash
+	}
+	switch {
+	case !findDuplicates:
+	case !haveLast:
+		text += "\n\nNo previous analysis to compare against; run analyze_duplicates first."
+	case len(report.KnownDuplicates) == 0:
+		text += fmt.Sprintf("\n\nNo known duplicates in %s.", report.AnalyzedIn)
+	default:
+		text += fmt.Sprintf("\n\nKnown duplicates in %s:", report.AnalyzedIn)
+		for _, m := range report.KnownDuplicates {
+			text += fmt.Sprintf("\n  [%s] %.0f%% %s", m.MatchType, m.Similarity*100, m.TargetPath)
+		}
+	}
+
 	return map[string]interface{}{
 		"content": []map[string]interface{}{
-			{
-				"type": "text",
-				"text": fmt.Sprintf(
-					"File: %s\n\nThis would return:\n- Content hash (SHA256)\n- Merkle root\n- File size\n- Chunk count",
-					filePath,
-				),
-			},
+			textContent(text),
+			textContent(string(structured)),
 		},
+		"structuredContent": report,
 	}, nil
 }
+
+func hashFile(file dupes.JSFile, spec dupes.ChunkerSpec, hashName string) (dupes.FileTree, error) {
+	chunker, err := dupes.NewChunker(spec)
+	if err != nil {
+		return dupes.FileTree{}, err
+	}
+	hash, err := dupes.LookupHashAlgorithm(hashName)
+	if err != nil {
+		return dupes.FileTree{}, err
+	}
+	return dupes.ProcessFile(file, chunker, hash)
+}
+
+// sha256File streams the whole-file digest without loading the file.
+func sha256File(file dupes.JSFile) (string, error) {
+	r, err := file.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,357 @@
+//go:build !js
+
+// mcp-session.go - Cached analyses for the MCP server
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+	"github.com/vinodhalaharvi/pure-dupes/hashdb"
+)
+
+// analysisRequest holds the arguments that determine an analysis result.
+// Two requests with the same key can share a cached DedupResult.
+type analysisRequest struct {
+	Directory string
+	Threshold float64
+	MaxDepth  int
+	ChunkSize int
+	Metric    string
+}
+
+func parseAnalysisRequest(args map[string]interface{}) (analysisRequest, error) {
+	directory, ok := args["directory"].(string)
+	if !ok {
+		return analysisRequest{}, fmt.Errorf("directory parameter required")
+	}
+
+	abs, err := filepath.Abs(directory)
+	if err != nil {
+		return analysisRequest{}, err
+	}
+
+	req := analysisRequest{
+		Directory: abs,
+		Threshold: 0.8,
+		MaxDepth:  10,
+		ChunkSize: 4096,
+		Metric:    "overlap",
+	}
+	if t, ok := args["threshold"].(float64); ok {
+		req.Threshold = t
+	}
+	if d, ok := args["max_depth"].(float64); ok {
+		req.MaxDepth = int(d)
+	}
+	if c, ok := args["chunk_size"].(float64); ok {
+		req.ChunkSize = int(c)
+	}
+	if m, ok := args["metric"].(string); ok {
+		metric, err := dupes.LookupSimilarityMetric(m)
+		if err != nil {
+			return analysisRequest{}, err
+		}
+		req.Metric = metric.Name
+	}
+	return req, nil
+}
+
+func (r analysisRequest) key() string {
+	return fmt.Sprintf("%s|%.4f|%d|%d|%s", r.Directory, r.Threshold, r.MaxDepth, r.ChunkSize, r.Metric)
+}
+
+// scanID names the analysis of r in resource URIs. It depends only on the
+// request, so re-running a stale scan keeps its URIs.
+func (r analysisRequest) scanID() string {
+	sum := sha256.Sum256([]byte(r.key()))
+	return hex.EncodeToString(sum[:6])
+}
+
+type fileStat struct {
+	Size    int64
+	ModTime int64
+}
+
+// scanEntry is one completed analysis together with the stats needed to tell
+// whether it is still current.
+type scanEntry struct {
+	ID        string
+	Request   analysisRequest
+	Result    dupes.DedupResult
+	Trees     []dupes.FileTree    // per-file hashes, for lookups against this analysis
+	Files     map[string]fileStat // files that were analyzed
+	Dirs      map[string]int64    // directory mtimes, to notice added or removed files
+	CreatedAt time.Time
+
+	indexOnce  sync.Once
+	chunkIndex map[string][]int // chunk hash -> indices into Trees, built on first use
+	byPath     map[string]int
+}
+
+// index returns the chunk index and path lookup for the entry's trees.
+func (e *scanEntry) index() (map[string][]int, map[string]int) {
+	e.indexOnce.Do(func() {
+		e.chunkIndex = dupes.BuildChunkIndex(e.Trees)
+		e.byPath = make(map[string]int, len(e.Trees))
+		for i, ft := range e.Trees {
+			e.byPath[ft.Path] = i
+		}
+	})
+	return e.chunkIndex, e.byPath
+}
+
+// stale reports whether any analyzed file or directory changed on disk.
+func (e *scanEntry) stale() bool {
+	for path, st := range e.Files {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != st.Size || info.ModTime().UnixMilli() != st.ModTime {
+			return true
+		}
+	}
+	for dir, mtime := range e.Dirs {
+		info, err := os.Stat(dir)
+		if err != nil || info.ModTime().UnixMilli() != mtime {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionStore caches analyses in memory and, when cacheDir is set, on disk
+// so they survive server restarts.
+type sessionStore struct {
+	mu       sync.Mutex
+	entries  map[string]*scanEntry
+	last     *scanEntry // most recently analyzed or looked up
+	cacheDir string
+
+	// hashes, when set, caches file signatures so re-analysis only rehashes
+	// files that changed
+	hashes *hashdb.DB
+
+	// onUpdate, when set, is called after each new analysis is stored
+	onUpdate func(entry *scanEntry)
+}
+
+func newSessionStore(cacheDir string) *sessionStore {
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+			log.Printf("Disabling on-disk cache: %v", err)
+			cacheDir = ""
+		}
+	}
+	return &sessionStore{
+		entries:  make(map[string]*scanEntry),
+		cacheDir: cacheDir,
+	}
+}
+
+// lookup returns a cached analysis if it is still current.
+func (s *sessionStore) lookup(req analysisRequest) (*scanEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := req.key()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry, ok = s.load(key)
+	}
+	if !ok {
+		return nil, false
+	}
+
+	if entry.stale() {
+		log.Printf("Cached analysis of %s is stale", req.Directory)
+		delete(s.entries, key)
+		s.remove(key)
+		return nil, false
+	}
+
+	s.entries[key] = entry
+	s.last = entry
+	return entry, true
+}
+
+// latest returns the most recent analysis, if any.
+func (s *sessionStore) latest() (*scanEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.last != nil
+}
+
+// find returns the analysis with the given scan ID.
+func (s *sessionStore) find(id string) (*scanEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// list returns the analyses held in memory, oldest first.
+func (s *sessionStore) list() []*scanEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*scanEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// analyze returns the cached analysis for req or runs a new one. A scan
+// stops early when tc's context is cancelled.
+func (s *sessionStore) analyze(tc toolContext, req analysisRequest) (*scanEntry, error) {
+	if entry, ok := s.lookup(req); ok {
+		log.Printf("Using cached analysis of %s", req.Directory)
+		return entry, nil
+	}
+
+	chunker, err := dupes.NewChunker(dupes.ChunkerSpec{Name: "fixed", Size: req.ChunkSize})
+	if err != nil {
+		return nil, err
+	}
+
+	metric, err := dupes.LookupSimilarityMetric(req.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := dupes.DefaultOptions()
+	opts.Threshold = req.Threshold
+	opts.Chunker = chunker
+	opts.Metric = metric
+	opts.Progress = tc.progress
+	opts.OnError = func(path string, err error) {
+		log.Printf("Could not read %s: %v", path, err)
+	}
+
+	files, err := dupes.CollectFilesContext(tc.ctx, []string{req.Directory}, dupes.WalkOptions{
+		MaxDepth: req.MaxDepth,
+		OnError: func(path string, err error) {
+			log.Printf("Skipping %s: %v", path, err)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result dupes.DedupResult
+	var trees []dupes.FileTree
+	if s.hashes != nil {
+		result, trees, err = hashdb.Analyze(tc.ctx, s.hashes, files, opts)
+	} else {
+		result, trees, err = dupes.AnalyzeContext(tc.ctx, files, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &scanEntry{
+		ID:        req.scanID(),
+		Request:   req,
+		Result:    result,
+		Trees:     trees,
+		Files:     make(map[string]fileStat, len(files)),
+		Dirs:      map[string]int64{},
+		CreatedAt: time.Now(),
+	}
+
+	dirs := []string{req.Directory}
+	for _, f := range files {
+		entry.Files[f.Path] = fileStat{Size: f.Size, ModTime: f.ModTime}
+		dirs = append(dirs, filepath.Dir(f.Path))
+	}
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil {
+			entry.Dirs[dir] = info.ModTime().UnixMilli()
+		}
+	}
+
+	s.mu.Lock()
+	s.entries[req.key()] = entry
+	s.last = entry
+	s.save(req.key(), entry)
+	s.mu.Unlock()
+
+	if s.onUpdate != nil {
+		s.onUpdate(entry)
+	}
+
+	return entry, nil
+}
+
+// ============================================================================
+// ON-DISK CACHE
+// ============================================================================
+
+func (s *sessionStore) cachePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.cacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *sessionStore) load(key string) (*scanEntry, bool) {
+	if s.cacheDir == "" {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(s.cachePath(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry scanEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("Ignoring unreadable cache entry: %v", err)
+		return nil, false
+	}
+	if entry.ID == "" {
+		entry.ID = entry.Request.scanID()
+	}
+	return &entry, true
+}
+
+func (s *sessionStore) save(key string, entry *scanEntry) {
+	if s.cacheDir == "" {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding cache entry: %v", err)
+		return
+	}
+
+	tmp := s.cachePath(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("Error writing cache entry: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.cachePath(key)); err != nil {
+		log.Printf("Error writing cache entry: %v", err)
+	}
+}
+
+func (s *sessionStore) remove(key string) {
+	if s.cacheDir != "" {
+		os.Remove(s.cachePath(key))
+	}
+}
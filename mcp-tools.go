@@ -0,0 +1,430 @@
+//go:build !js
+
+// mcp-tools.go - Comparison and cleanup tools for the MCP server
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/vinodhalaharvi/pure-dupes/dupes"
+)
+
+// ============================================================================
+// COMPARE FILES
+// ============================================================================
+
+// ComparisonReport is the structured payload returned by compare_files.
+type ComparisonReport struct {
+	FileA          string                 `json:"fileA"`
+	FileB          string                 `json:"fileB"`
+	Identical      bool                   `json:"identical"`
+	Similarity     float64                `json:"similarity"`        // share of A's chunks found in B
+	ReverseSim     float64                `json:"reverseSimilarity"` // share of B's chunks found in A
+	Scores         dupes.SimilarityScores `json:"scores"`
+	MatchedBytes   int64                  `json:"matchedBytes"`
+	MatchingRanges []dupes.ChunkRange     `json:"matchingRanges"`
+	Truncated      bool                   `json:"truncated,omitempty"`
+}
+
+func compareFilesTool(args map[string]interface{}) (interface{}, error) {
+	pathA, okA := args["file_a"].(string)
+	pathB, okB := args["file_b"].(string)
+	if !okA || !okB {
+		return nil, fmt.Errorf("file_a and file_b parameters required")
+	}
+
+	spec := dupes.ChunkerSpec{Name: "fixed", Size: 4096}
+	if c, ok := args["chunker"].(string); ok {
+		spec.Name = c
+	}
+	if c, ok := args["chunk_size"].(float64); ok {
+		spec.Size = int(c)
+	}
+	maxRanges := 100
+	if m, ok := args["max_ranges"].(float64); ok && m > 0 {
+		maxRanges = int(m)
+	}
+
+	a, err := loadAndHash(pathA, spec, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	b, err := loadAndHash(pathB, spec, "sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	// Low-entropy chunks such as zero fill are left out, as a scan does
+	stop := lowEntropyLeaves(a, b)
+	ranges := dupes.MatchingRanges(a, b, stop)
+	a, b = dupes.WithoutChunks(a, stop), dupes.WithoutChunks(b, stop)
+	report := ComparisonReport{
+		FileA:          a.Path,
+		FileB:          b.Path,
+		Identical:      dupes.RootKey(a) == dupes.RootKey(b),
+		Similarity:     dupes.CompareFiles(a, b),
+		ReverseSim:     dupes.CompareFiles(b, a),
+		Scores:         dupes.ScoreFiles(a, b),
+		MatchingRanges: ranges,
+	}
+	for _, r := range ranges {
+		report.MatchedBytes += r.Length
+	}
+	if len(ranges) > maxRanges {
+		report.MatchingRanges = ranges[:maxRanges]
+		report.Truncated = true
+	}
+
+	text := fmt.Sprintf(
+		"Comparing %s with %s (%s chunker, %d bytes)\n\n- Identical: %v\n- Chunks of A found in B: %.1f%%\n- Chunks of B found in A: %.1f%%\n- Jaccard, repeats counted: %.1f%%\n- Longest shared run: %.1f%%\n- Matching bytes: %d in %d ranges",
+		report.FileA, report.FileB, spec.Name, spec.Size,
+		report.Identical, report.Similarity*100, report.ReverseSim*100,
+		report.Scores.Jaccard*100, report.Scores.LongestRun*100, report.MatchedBytes, len(ranges),
+	)
+	for _, r := range report.MatchingRanges {
+		text += fmt.Sprintf("\n  A[%d:%d] = B[%d:%d]", r.AOffset, r.AOffset+r.Length, r.BOffset, r.BOffset+r.Length)
+	}
+	if report.Truncated {
+		text += fmt.Sprintf("\n  ... %d more ranges", len(ranges)-maxRanges)
+	}
+
+	return structuredResult(text, report)
+}
+
+// lowEntropyLeaves is the set of leaves flagged low entropy in any of fts.
+func lowEntropyLeaves(fts ...dupes.FileTree) map[string]bool {
+	stop := map[string]bool{}
+	for _, ft := range fts {
+		for _, i := range ft.LowEntropy {
+			if i >= 0 && i < len(ft.Leaves) {
+				stop[ft.Leaves[i]] = true
+			}
+		}
+	}
+	return stop
+}
+
+// ============================================================================
+// FIND SIMILAR
+// ============================================================================
+
+// SimilarReport is the structured payload returned by find_similar_to.
+type SimilarReport struct {
+	Path       string                 `json:"path"`
+	AnalyzedIn string                 `json:"analyzedIn"`
+	Threshold  float64                `json:"threshold"`
+	Matches    []dupes.DuplicateMatch `json:"matches"`
+}
+
+func findSimilarToTool(args map[string]interface{}) (interface{}, error) {
+	filePath, ok := args["file_path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("file_path parameter required")
+	}
+
+	last, ok := sessions.latest()
+	if !ok {
+		return nil, fmt.Errorf("no analysis to search; run analyze_duplicates first")
+	}
+
+	threshold := last.Request.Threshold
+	if t, ok := args["threshold"].(float64); ok {
+		threshold = t
+	}
+	limit := 20
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+
+	// Hash with the analysis's settings so chunk hashes line up with its index
+	ft, err := loadAndHash(filePath, last.Result.Chunker, last.Result.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := similarTo(ft, last, threshold)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	report := SimilarReport{
+		Path:       ft.Path,
+		AnalyzedIn: last.Request.Directory,
+		Threshold:  threshold,
+		Matches:    matches,
+	}
+
+	text := fmt.Sprintf("Files in %s similar to %s (threshold %.0f%%):", report.AnalyzedIn, report.Path, threshold*100)
+	if len(matches) == 0 {
+		text += "\n  none"
+	}
+	for _, m := range matches {
+		text += fmt.Sprintf("\n  [%s] %.0f%% %s", m.MatchType, m.Similarity*100, m.TargetPath)
+	}
+
+	return structuredResult(text, report)
+}
+
+// similarTo finds files of an analysis that match ft exactly, score at least
+// threshold on the analysis's metric, or look alike by perceptual hash. The
+// analysis's stop chunks are left out of scoring, as they were in the scan.
+func similarTo(ft dupes.FileTree, entry *scanEntry, threshold float64) []dupes.DuplicateMatch {
+	chunkIndex, byPath := entry.index()
+	selfIdx, inSet := byPath[ft.Path]
+	metric, _ := dupes.LookupSimilarityMetric(entry.Result.Metric)
+	stop := dupes.StopChunkSet(entry.Result.StopChunks)
+	ft = dupes.WithoutChunks(ft, stop)
+
+	matches := []dupes.DuplicateMatch{}
+	seen := make(map[int]bool)
+
+	addMatch := func(idx int, similarity float64, matchType string, shared int64, scores *dupes.SimilarityScores) {
+		if seen[idx] || (inSet && idx == selfIdx) {
+			return
+		}
+		seen[idx] = true
+		matches = append(matches, dupes.DuplicateMatch{
+			TargetPath: entry.Trees[idx].Path,
+			Similarity: similarity,
+			SharedSize: shared,
+			MatchType:  matchType,
+			Scores:     scores,
+		})
+	}
+
+	// Exact matches first: empty or prefiltered files have no chunks to index
+	key := dupes.RootKey(ft)
+	for idx, other := range entry.Trees {
+		if dupes.RootKey(other) == key {
+			addMatch(idx, 1.0, "exact", ft.Size, nil)
+		}
+	}
+
+	for idx := range dupes.FindCandidates(ft, chunkIndex, metric.CandidateThreshold(threshold)) {
+		scores := dupes.ScoreFiles(ft, dupes.WithoutChunks(entry.Trees[idx], stop))
+		// Only equal roots are exact; those were added above
+		if similarity := metric.Of(scores); similarity >= threshold {
+			addMatch(idx, similarity, "partial", int64(float64(ft.Size)*similarity), &scores)
+		}
+	}
+
+	if ft.IsImage && ft.PHash != 0 {
+		for idx, other := range entry.Trees {
+			if !other.IsImage || other.PHash == 0 {
+				continue
+			}
+			if similarity := dupes.HashSimilarity(ft.PHash, other.PHash); similarity >= 0.85 {
+				addMatch(idx, similarity, "visual", ft.Size, nil)
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].TargetPath < matches[j].TargetPath
+	})
+	return matches
+}
+
+// ============================================================================
+// PLAN CLEANUP
+// ============================================================================
+
+// CleanupAction says which file of a group to keep and which to remove.
+type CleanupAction struct {
+	GroupType    string   `json:"groupType"`
+	Similarity   float64  `json:"similarity"`
+	Keep         string   `json:"keep"`
+	Remove       []string `json:"remove"`
+	ReclaimBytes int64    `json:"reclaimBytes"`
+}
+
+// CleanupPlan is the structured payload returned by plan_cleanup.
+type CleanupPlan struct {
+	Directory    string          `json:"directory"`
+	KeepPolicy   string          `json:"keepPolicy"`
+	Actions      []CleanupAction `json:"actions"`
+	FilesRemoved int             `json:"filesRemoved"`
+	ReclaimBytes int64           `json:"reclaimBytes"`
+}
+
+func planCleanupTool(tc toolContext, args map[string]interface{}) (interface{}, error) {
+	req, err := parseAnalysisRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := "oldest"
+	if p, ok := args["keep"].(string); ok {
+		policy = p
+	}
+	better, err := keepPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[string]bool{"exact": true}
+	if list, ok := args["group_types"].([]interface{}); ok && len(list) > 0 {
+		types = map[string]bool{}
+		for _, t := range list {
+			if name, ok := t.(string); ok {
+				types[name] = true
+			}
+		}
+	}
+
+	entry, err := sessions.analyze(tc, req)
+	if err != nil {
+		return nil, err
+	}
+	_, byPath := entry.index()
+
+	plan := CleanupPlan{
+		Directory:  req.Directory,
+		KeepPolicy: policy,
+		Actions:    []CleanupAction{},
+	}
+
+	type candidate struct {
+		group   dupes.DuplicateGroup
+		members []dupes.FileTree
+	}
+	candidates := []candidate{}
+	for _, group := range entry.Result.DuplicateGroups {
+		if !types[group.GroupType] {
+			continue
+		}
+
+		members := []dupes.FileTree{}
+		for _, path := range group.Files {
+			if idx, ok := byPath[path]; ok {
+				members = append(members, entry.Trees[idx])
+			}
+		}
+		if len(members) >= 2 {
+			candidates = append(candidates, candidate{group, members})
+		}
+	}
+
+	// Groups overlap when several types are asked for, so every keep is
+	// chosen before anything is removed. A group that holds a file another
+	// group already keeps keeps that file too, and no action removes a file
+	// any action keeps, so each group leaves a survivor.
+	kept := make(map[string]bool)
+	keeps := dupes.Map(candidates, func(c candidate) dupes.FileTree {
+		pool := dupes.Filter(c.members, func(ft dupes.FileTree) bool { return kept[ft.Path] })
+		if len(pool) == 0 {
+			pool = c.members
+		}
+		keep := pool[0]
+		for _, ft := range pool[1:] {
+			if better(ft, keep) {
+				keep = ft
+			}
+		}
+		kept[keep.Path] = true
+		return keep
+	})
+
+	removed := make(map[string]bool)
+	for i, c := range candidates {
+		action := CleanupAction{
+			GroupType:  c.group.GroupType,
+			Similarity: c.group.Similarity,
+			Keep:       keeps[i].Path,
+			Remove:     []string{},
+		}
+		for _, ft := range c.members {
+			if kept[ft.Path] {
+				continue
+			}
+			action.Remove = append(action.Remove, ft.Path)
+			action.ReclaimBytes += ft.Size
+			// A file in several groups is only removed, and counted, once
+			if !removed[ft.Path] {
+				removed[ft.Path] = true
+				plan.FilesRemoved++
+				plan.ReclaimBytes += ft.Size
+			}
+		}
+		if len(action.Remove) > 0 {
+			plan.Actions = append(plan.Actions, action)
+		}
+	}
+
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		return plan.Actions[i].ReclaimBytes > plan.Actions[j].ReclaimBytes
+	})
+
+	text := fmt.Sprintf(
+		"Cleanup plan for %s (keep %s). Nothing has been deleted.\n\n- Groups: %d\n- Files to remove: %d\n- Space reclaimed: %d bytes",
+		plan.Directory, policy, len(plan.Actions), plan.FilesRemoved, plan.ReclaimBytes,
+	)
+	for _, a := range plan.Actions {
+		text += fmt.Sprintf("\n\n[%s] keep %s", a.GroupType, a.Keep)
+		for _, r := range a.Remove {
+			text += "\n  remove " + r
+		}
+	}
+
+	return structuredResult(text, plan)
+}
+
+// keepPolicy returns a function reporting whether a should be kept over b.
+func keepPolicy(name string) (func(a, b dupes.FileTree) bool, error) {
+	switch name {
+	case "oldest":
+		return func(a, b dupes.FileTree) bool { return a.ModTime < b.ModTime }, nil
+	case "newest":
+		return func(a, b dupes.FileTree) bool { return a.ModTime > b.ModTime }, nil
+	case "shortest_path":
+		return func(a, b dupes.FileTree) bool { return len(a.Path) < len(b.Path) }, nil
+	case "largest":
+		return func(a, b dupes.FileTree) bool { return a.Size > b.Size }, nil
+	case "first":
+		return func(a, b dupes.FileTree) bool { return false }, nil
+	default:
+		return nil, fmt.Errorf("unknown keep policy: %s", name)
+	}
+}
+
+// ============================================================================
+// HELPERS
+// ============================================================================
+
+func loadAndHash(path string, spec dupes.ChunkerSpec, hashName string) (dupes.FileTree, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return dupes.FileTree{}, err
+	}
+
+	files, err := dupes.CollectFiles([]string{abs}, dupes.WalkOptions{})
+	if err != nil {
+		return dupes.FileTree{}, err
+	}
+	if len(files) != 1 {
+		return dupes.FileTree{}, fmt.Errorf("%s is not a regular file", abs)
+	}
+
+	return hashFile(files[0], spec, hashName)
+}
+
+func structuredResult(text string, payload interface{}) (interface{}, error) {
+	structured, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"content": []map[string]interface{}{
+			textContent(text),
+			textContent(string(structured)),
+		},
+		"structuredContent": payload,
+	}, nil
+}
@@ -77,7 +77,7 @@ else
 fi
 
 # Test MCP server build
-if go build -o test_mcp mcp-server.go 2>/dev/null; then
+if go build -o test_mcp . 2>/dev/null; then
     pass "MCP server compiles successfully"
     
     # Test MCP server responds
@@ -99,19 +99,19 @@ echo ""
 echo "${BLUE}Test 3: Validating code...${NC}"
 
 # Check for Phase 1 features in WASM code
-if grep -q "reportProgress" main_wasm_enhanced.go; then
+if grep -rq "reportProgress" main_wasm_enhanced.go dupes/; then
     pass "Progress reporting code present"
 else
     fail "Progress reporting code missing"
 fi
 
-if grep -q "CreateSmartGroups" main_wasm_enhanced.go; then
+if grep -rq "CreateSmartGroups" main_wasm_enhanced.go dupes/; then
     pass "Smart groups code present"
 else
     fail "Smart groups code missing"
 fi
 
-if grep -q "ModTime" main_wasm_enhanced.go; then
+if grep -rq "ModTime" main_wasm_enhanced.go dupes/; then
     pass "Caching support code present"
 else
     fail "Caching support code missing"
@@ -143,6 +143,15 @@ else
     fail "Cache operations missing"
 fi
 
+# index.html inlines CacheDB; every method it calls must exist in both copies
+for method in $(grep -o "cacheDB\.[a-zA-Z]*" index.html | cut -d. -f2 | sort -u); do
+    if grep -q "async $method(" cache-db.js && grep -q "async $method(" index.html; then
+        pass "CacheDB.$method in cache-db.js and index.html"
+    else
+        fail "CacheDB.$method missing from cache-db.js or the copy in index.html"
+    fi
+done
+
 # Check HTML has all features
 if grep -q "Web Worker" index_phase1.html; then
     pass "HTML references Worker"
@@ -63,7 +63,22 @@ self.onmessage = async function(e) {
         }
         
         try {
-            const {files, threshold, chunkSize} = data;
+            const {files, cached, threshold, chunkSize, options} = data;
+
+            // Files sent as a Blob are streamed: WASM pulls one slice at a
+            // time through read() instead of receiving the whole content.
+            const reader = new FileReaderSync();
+            const inputs = files.map(file => {
+                if (!file.blob) {
+                    return file;
+                }
+                const {blob, ...rest} = file;
+                return {
+                    ...rest,
+                    read: (offset, length) =>
+                        new Uint8Array(reader.readAsArrayBuffer(blob.slice(offset, offset + length)))
+                };
+            });
             
             // Progress callback
             const progressCallback = (progress) => {
@@ -73,15 +88,27 @@ self.onmessage = async function(e) {
                 });
             };
             
-            // Call WASM function with progress callback
-            const resultJSON = analyzeFiles(
-                files,
+            // Call WASM function with progress callback. Only files without
+            // a usable cached signature are hashed, and their signatures come
+            // back for the page to cache; a cold run passes none.
+            const resultJSON = analyzeIncremental(
+                inputs,
+                cached || [],
                 threshold,
                 chunkSize,
-                progressCallback
+                progressCallback,
+                options || {}
             );
             
-            const result = JSON.parse(resultJSON);
+            // Errors come back as an object rather than JSON
+            let result = typeof resultJSON === 'string' ? JSON.parse(resultJSON) : resultJSON;
+            if (result.signatures) {
+                self.postMessage({
+                    type: 'signatures',
+                    data: result.signatures
+                });
+                result = result.result;
+            }
             
             if (result.error) {
                 self.postMessage({